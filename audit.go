@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/acd/infinitive/infinity"
+	log "github.com/sirupsen/logrus"
+)
+
+// auditEntry records an attempt to write raw bytes to a table, or to send a
+// raw request changing a device's state.
+type auditEntry struct {
+	Time   time.Time `json:"time"`
+	Client string    `json:"client"`
+	// What was asked for, whether or not it could be done.  Raw requests
+	// have an operation instead of a table and prefix.
+	Device string `json:"device"`
+	Op     string `json:"op,omitempty"`
+	Table  string `json:"table,omitempty"`
+	Prefix string `json:"prefix,omitempty"`
+	Data   string `json:"data"`
+	DryRun bool   `json:"dryRun"`
+	// Filled in when the table could be read
+	Write *infinity.RawWrite `json:"write,omitempty"`
+	// The data carried by the response to a raw request
+	Response string `json:"response,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// auditLog logs raw writes, and appends them to a file as JSON lines if it
+// has one.
+type auditLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func openAuditLog(path string) (*auditLog, error) {
+	a := &auditLog{}
+	if path == "" {
+		return a, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	a.file = f
+	return a, nil
+}
+
+func (a *auditLog) record(e auditEntry) {
+	fields := log.Fields{
+		"client": e.Client,
+		"device": e.Device,
+		"data":   e.Data,
+	}
+	if e.Op != "" {
+		fields["op"] = e.Op
+		fields["response"] = e.Response
+	} else {
+		fields["table"] = e.Table
+		fields["prefix"] = e.Prefix
+		fields["dryRun"] = e.DryRun
+	}
+	if e.Write != nil {
+		fields["before"] = e.Write.Before
+		fields["after"] = e.Write.After
+	}
+	if e.Error != "" {
+		fields["error"] = e.Error
+	}
+	if e.Op != "" {
+		log.WithFields(fields).Warn("raw request")
+	} else {
+		log.WithFields(fields).Warn("raw table write")
+	}
+
+	if a.file == nil {
+		return
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		log.Errorf("error encoding audit entry: %s", err.Error())
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		log.Errorf("error writing audit log: %s", err.Error())
+	}
+}
+
+func (a *auditLog) Close() error {
+	if a.file == nil {
+		return nil
+	}
+	return a.file.Close()
+}
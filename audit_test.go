@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRejectedRawWritesAreAudited(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	audit, err := openAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.Close()
+
+	ws := &webserver{audit: audit}
+	srv := httptest.NewServer(ws.buildEngine())
+	defer srv.Close()
+
+	send := func(method string, url string, body string) int {
+		req, _ := http.NewRequest(method, srv.URL+url, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	tests := []struct {
+		method string
+		url    string
+		body   string
+		allow  bool
+		status int
+		error  string
+	}{
+		{"PUT", "/api/raw/2001/003b03", `{"prefix":"000004","data":"44"}`, false, 403, "raw writes are disabled"},
+		{"PUT", "/api/raw/200x/003b03", `{"prefix":"000004","data":"44"}`, true, 400, "4 character hex string"},
+		{"PUT", "/api/raw/2001/3b03", `{"prefix":"000004","data":"44"}`, true, 400, "6 character hex string"},
+		{"PUT", "/api/raw/2001/003b03", `{"prefix":"04","data":"44"}`, true, 400, "prefix must be"},
+		{"PUT", "/api/raw/2001/003b03", `{"prefix":"000004","data":"4g"}`, true, 400, "data must be"},
+		{"POST", "/api/raw/2001/op/force", `{"data":"0001"}`, false, 403, "raw writes are disabled"},
+		{"POST", "/api/raw/2001/op/write", `{"data":"zz"}`, true, 400, "data must be"},
+	}
+	for _, tt := range tests {
+		ws.allowRawWrite = tt.allow
+		if status := send(tt.method, tt.url, tt.body); status != tt.status {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.url, status, tt.status)
+		}
+	}
+	// Requests that don't change state aren't audited
+	if status := send("POST", "/api/raw/2001/op/rdvar", `{"data":"zz"}`); status != 400 {
+		t.Errorf("bad RDVAR: status %d, want 400", status)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []auditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+
+	if len(entries) != len(tests) {
+		t.Fatalf("audited %d attempts, want %d", len(entries), len(tests))
+	}
+	for i, tt := range tests {
+		if e := entries[i]; !strings.Contains(e.Error, tt.error) || e.Data == "" {
+			t.Errorf("%s %s audited as %+v, want error containing %q", tt.method, tt.url, e, tt.error)
+		}
+	}
+	if e := entries[0]; e.Device != "2001" || e.Table != "003b03" || e.Prefix != "000004" || e.Data != "44" {
+		t.Errorf("refused write audited as %+v", e)
+	}
+	if e := entries[5]; e.Op != "FORCE" || e.Data != "0001" {
+		t.Errorf("refused FORCE audited as %+v", e)
+	}
+}
@@ -5,6 +5,10 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/acd/infinitive/infinity"
 	log "github.com/sirupsen/logrus"
@@ -12,22 +16,110 @@ import (
 
 func main() {
 	httpPort := flag.Int("httpport", 8080, "HTTP port to listen on")
-	serialPort := flag.String("serial", "", "path to serial port")
+	serialPort := flag.String("serial", "", "path to serial port, or tcp://host:port or rfc2217://host:port of a network serial bridge")
+	capture := flag.String("capture", "", "record bus traffic to a pcapng file")
+	passive := flag.Bool("passive", false, "listen only: never transmit on the bus")
+	idleGap := flag.Duration("idlegap", 20*time.Millisecond, "how long the bus must be quiet before transmitting")
+	replay := flag.String("replay", "", "replay bus traffic from a pcapng capture instead of using a serial port")
+	alarms := flag.String("alarms", "", "file to keep the alarm history in")
+	address := flag.String("address", "9201", "bus address to transmit from, as 4 hex digits")
+	conflictWatch := flag.Duration("conflictwatch", 5*time.Second, "how long to watch for another device using our address before transmitting, 0 to skip")
+	silence := flag.Duration("silence", 5*time.Second, "reopen the serial port when no data was received for this long, 0 to never")
+	failureHook := flag.String("failurehook", "", "shell command to run when the serial port keeps failing")
+	failureHookAfter := flag.Int("failurehookafter", 5, "consecutive serial port failures before running the failure hook")
+	onConflict := flag.String("onconflict", "exit", "what to do if another device is using our address: exit or passive")
+	dissect := flag.Bool("dissect", false, "log frames with the devices, tables and fields they involve")
+	allowRawWrite := flag.Bool("allow-raw-write", false, "allow writing raw bytes to any table through PUT /api/raw/:device/:table")
+	auditPath := flag.String("auditlog", "", "file to append an entry to for every raw table write")
+	tables := flag.String("tables", "", "YAML or JSON table definitions file, or a directory of them, to decode tables with")
 
 	flag.Parse()
 
-	if len(*serialPort) == 0 {
-		fmt.Print("must provide serial\n")
+	if len(*serialPort) == 0 && len(*replay) == 0 {
+		fmt.Print("must provide serial or replay\n")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
+	addr, err := strconv.ParseUint(*address, 16, 16)
+	if err != nil || len(*address) != 4 {
+		fmt.Printf("invalid address: %s\n", *address)
+		os.Exit(1)
+	}
+	if *onConflict != "exit" && *onConflict != "passive" {
+		fmt.Printf("invalid onconflict: %s\n", *onConflict)
+		os.Exit(1)
+	}
+
 	log.SetLevel(log.DebugLevel)
 
-	infinityApi, err := infinity.NewApi(context.Background(), *serialPort)
+	opts := []infinity.BusOption{
+		infinity.WithIdleGap(*idleGap),
+		infinity.WithAddress(uint16(addr)),
+		infinity.WithConflictWatch(*conflictWatch, *onConflict == "passive"),
+		infinity.WithSilenceThreshold(*silence),
+		infinity.WithFailureHook(*failureHook, *failureHookAfter),
+	}
+	var captureFile *os.File
+	if len(*capture) > 0 {
+		captureFile, err = os.Create(*capture)
+		if err != nil {
+			log.Panicf("error creating capture file: %s", err.Error())
+		}
+		c, err := infinity.NewCapture(captureFile)
+		if err != nil {
+			log.Panicf("error writing capture file: %s", err.Error())
+		}
+		opts = append(opts, infinity.WithCapture(c))
+	}
+	if *passive {
+		opts = append(opts, infinity.WithPassive())
+	}
+	if *dissect {
+		opts = append(opts, infinity.WithDissection())
+	}
+	if len(*replay) > 0 {
+		opts = append(opts, infinity.WithReplay(*replay))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	infinityApi, err := infinity.NewApi(ctx, *serialPort, opts...)
 	if err != nil {
-		log.Panicf("error opening serial port: %s", err.Error())
+		log.Panicf("error opening bus interface: %s", err.Error())
+	}
+	if len(*alarms) > 0 {
+		if err := infinityApi.PersistAlarms(*alarms); err != nil {
+			log.Panicf("error loading alarm history: %s", err.Error())
+		}
+	}
+	if len(*tables) > 0 {
+		if err := infinityApi.LoadTableDefinitions(*tables); err != nil {
+			log.Panicf("error loading table definitions: %s", err.Error())
+		}
+	}
+
+	audit, err := openAuditLog(*auditPath)
+	if err != nil {
+		log.Panicf("error opening audit log: %s", err.Error())
+	}
+	if *allowRawWrite {
+		log.Warn("raw table writes are enabled")
+	}
+
+	err = launchWebserver(ctx, *httpPort, infinityApi, *allowRawWrite, audit)
+	if err != nil {
+		log.Errorf("web server failed: %s", err.Error())
 	}
 
-	launchWebserver(*httpPort, infinityApi)
+	infinityApi.Close()
+	audit.Close()
+	if captureFile != nil {
+		captureFile.Close()
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+	log.Info("shut down cleanly")
 }
@@ -0,0 +1,196 @@
+package infinity
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Number of alarm events kept in the history
+const alarmHistoryLimit = 500
+
+// alarmDescriptions describes alarm codes.  These are the status codes
+// furnaces and fan coils flash on their control boards; it is unconfirmed
+// that alarm frames use the same numbering, so the raw frame data is always
+// reported alongside.
+var alarmDescriptions = map[uint8]string{
+	11: "no previous code",
+	12: "blower on after power up",
+	13: "limit circuit lockout",
+	14: "ignition lockout",
+	15: "blower motor lockout",
+	21: "gas heating lockout",
+	22: "abnormal flame-proving signal",
+	23: "pressure switch did not open",
+	24: "secondary voltage fuse is open",
+	25: "invalid model selection or setup error",
+	31: "pressure switch or relay did not close",
+	32: "low speed pressure switch did not close",
+	33: "limit circuit fault",
+	34: "ignition proving failure",
+	41: "blower motor fault",
+	42: "inducer motor fault",
+	43: "low pressure switch open",
+	44: "high pressure switch open",
+	45: "control circuitry lockout",
+	46: "brownout",
+	48: "communication fault",
+}
+
+// Alarm is a fault raised or cleared by a device.
+type Alarm struct {
+	Device      string    `json:"device"`
+	Class       string    `json:"class"`
+	Code        uint8     `json:"code"`
+	Description string    `json:"description"`
+	Active      bool      `json:"active"`
+	Time        time.Time `json:"time"`
+	// Data carried by the alarm frame, since the code, active flag and
+	// description are decoded from an unconfirmed layout
+	Raw string `json:"raw"`
+}
+
+// decodeAlarm decodes an alarm frame.  The layout is unconfirmed: the first
+// byte is taken as the alarm code and the second as whether it is active.
+// Devices that don't send the second byte are assumed to raise alarms.
+func decodeAlarm(f Frame, now time.Time) (Alarm, bool) {
+	if f.op != AlarmPacket || len(f.data) == 0 {
+		return Alarm{}, false
+	}
+
+	code := f.data[0]
+	description, ok := alarmDescriptions[code]
+	if !ok {
+		description = fmt.Sprintf("unknown alarm %d", code)
+	}
+	return Alarm{
+		Device:      fmt.Sprintf("%04x", f.src),
+		Class:       DeviceClass(f.src),
+		Code:        code,
+		Description: description,
+		Active:      len(f.data) < 2 || f.data[1] != 0,
+		Time:        now,
+		Raw:         hex.EncodeToString(f.data),
+	}, true
+}
+
+type alarmKey struct {
+	device string
+	code   uint8
+}
+
+// alarmHistory keeps the alarms raised and cleared on the bus, optionally
+// saving them to a file.
+type alarmHistory struct {
+	mu     sync.Mutex
+	path   string
+	events []Alarm
+	active map[alarmKey]Alarm
+}
+
+func newAlarmHistory() *alarmHistory {
+	return &alarmHistory{
+		active: make(map[alarmKey]Alarm),
+	}
+}
+
+// persist loads the history saved at path, if any, and saves it there from
+// now on.
+func (h *alarmHistory) persist(path string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var saved []Alarm
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return fmt.Errorf("reading alarm history %s: %w", path, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	events := append(saved, h.events...)
+	h.events = nil
+	h.active = make(map[alarmKey]Alarm)
+	for _, a := range events {
+		h.add(a)
+	}
+	h.path = path
+	return h.save()
+}
+
+// record adds an alarm seen on the bus, reporting whether it changed the
+// state of that alarm.  Devices repeat their alarms, so only changes are
+// kept.
+func (h *alarmHistory) record(a Alarm) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	_, active := h.active[alarmKey{a.Device, a.Code}]
+	if a.Active == active {
+		return false
+	}
+	h.add(a)
+	if err := h.save(); err != nil {
+		log.Errorf("error saving alarm history: %v", err)
+	}
+	return true
+}
+
+// add appends an alarm to the history.  h.mu must be held.
+func (h *alarmHistory) add(a Alarm) {
+	key := alarmKey{a.Device, a.Code}
+	if a.Active {
+		h.active[key] = a
+	} else {
+		delete(h.active, key)
+	}
+
+	h.events = append(h.events, a)
+	if len(h.events) > alarmHistoryLimit {
+		h.events = append([]Alarm{}, h.events[len(h.events)-alarmHistoryLimit:]...)
+	}
+}
+
+// save writes the history to its file, if it has one.  h.mu must be held.
+func (h *alarmHistory) save() error {
+	if h.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(h.events)
+	if err != nil {
+		return err
+	}
+	tmp := h.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.path)
+}
+
+// list returns the history, newest first, optionally only the alarms still
+// active.
+func (h *alarmHistory) list(activeOnly bool) []Alarm {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	alarms := []Alarm{}
+	for i := len(h.events) - 1; i >= 0; i-- {
+		a := h.events[i]
+		if activeOnly {
+			if current, ok := h.active[alarmKey{a.Device, a.Code}]; !ok || current != a {
+				continue
+			}
+		}
+		alarms = append(alarms, a)
+	}
+	return alarms
+}
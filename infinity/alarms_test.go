@@ -0,0 +1,124 @@
+package infinity
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func alarmFrame(src uint16, data ...byte) Frame {
+	return NewFrame(src, DevTSTAT, AlarmPacket, data)
+}
+
+func TestDecodeAlarm(t *testing.T) {
+	now := time.Date(2024, 1, 5, 17, 2, 11, 0, time.UTC)
+
+	a, ok := decodeAlarm(alarmFrame(0x4001, 14, 1, 0x7f), now)
+	if !ok {
+		t.Fatal("alarm frame not decoded")
+	}
+	want := Alarm{
+		Device:      "4001",
+		Class:       DeviceClass(0x4001),
+		Code:        14,
+		Description: "ignition lockout",
+		Active:      true,
+		Time:        now,
+		Raw:         "0e017f",
+	}
+	if a != want {
+		t.Errorf("got %+v, want %+v", a, want)
+	}
+
+	if a, _ := decodeAlarm(alarmFrame(0x4001, 14, 0), now); a.Active {
+		t.Error("alarm with a zero second byte decoded as active")
+	}
+	if a, _ := decodeAlarm(alarmFrame(0x4001, 99), now); !a.Active || a.Description != "unknown alarm 99" || a.Raw != "63" {
+		t.Errorf("unknown single byte alarm decoded as %+v", a)
+	}
+	if _, ok := decodeAlarm(alarmFrame(0x4001), now); ok {
+		t.Error("empty alarm frame decoded")
+	}
+	if _, ok := decodeAlarm(NewFrame(0x4001, DevTSTAT, ReadTableBlock, []byte{14, 1}), now); ok {
+		t.Error("read frame decoded as an alarm")
+	}
+}
+
+func testAlarm(device string, code uint8, active bool, minute int) Alarm {
+	return Alarm{
+		Device: device,
+		Code:   code,
+		Active: active,
+		Time:   time.Date(2024, 1, 5, 17, minute, 0, 0, time.UTC),
+	}
+}
+
+func TestAlarmHistoryTransitions(t *testing.T) {
+	h := newAlarmHistory()
+
+	steps := []struct {
+		alarm   Alarm
+		changed bool
+	}{
+		{testAlarm("4001", 14, false, 0), false},
+		{testAlarm("4001", 14, true, 1), true},
+		{testAlarm("4001", 14, true, 2), false},
+		{testAlarm("5001", 14, true, 3), true},
+		{testAlarm("4001", 33, true, 4), true},
+		{testAlarm("4001", 14, false, 5), true},
+		{testAlarm("4001", 14, false, 6), false},
+		{testAlarm("4001", 14, true, 7), true},
+	}
+	for i, s := range steps {
+		if got := h.record(s.alarm); got != s.changed {
+			t.Errorf("step %d: record(%+v) = %v, want %v", i, s.alarm, got, s.changed)
+		}
+	}
+
+	want := []Alarm{steps[7].alarm, steps[5].alarm, steps[4].alarm, steps[3].alarm, steps[1].alarm}
+	if got := h.list(false); !reflect.DeepEqual(got, want) {
+		t.Errorf("history:\n got %+v\nwant %+v", got, want)
+	}
+	want = []Alarm{steps[7].alarm, steps[4].alarm, steps[3].alarm}
+	if got := h.list(true); !reflect.DeepEqual(got, want) {
+		t.Errorf("active:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestAlarmHistoryLimit(t *testing.T) {
+	h := newAlarmHistory()
+	for i := 0; i < alarmHistoryLimit+10; i++ {
+		h.record(testAlarm("4001", 14, i%2 == 0, 0))
+	}
+	if got := len(h.list(false)); got != alarmHistoryLimit {
+		t.Errorf("history kept %d events, want %d", got, alarmHistoryLimit)
+	}
+}
+
+func TestAlarmHistoryPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alarms.json")
+
+	h := newAlarmHistory()
+	h.record(testAlarm("4001", 14, true, 0))
+	if err := h.persist(path); err != nil {
+		t.Fatal(err)
+	}
+	h.record(testAlarm("4001", 33, true, 1))
+	h.record(testAlarm("4001", 14, false, 2))
+	want := h.list(false)
+
+	loaded := newAlarmHistory()
+	if err := loaded.persist(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.list(false); !reflect.DeepEqual(got, want) {
+		t.Errorf("reloaded history:\n got %+v\nwant %+v", got, want)
+	}
+	if got := loaded.list(true); len(got) != 1 || got[0].Code != 33 {
+		t.Errorf("reloaded active alarms: %+v", got)
+	}
+	if loaded.record(testAlarm("4001", 33, true, 3)) {
+		t.Error("repeat of a reloaded active alarm recorded")
+	}
+}
@@ -3,7 +3,11 @@ package infinity
 import (
 	"bytes"
 	"context"
-	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/acd/infinitive/internal/cache"
@@ -15,51 +19,161 @@ const (
 	blowerCacheKey   = "blower"
 	heatpumpCacheKey = "heatpump"
 	tstatCacheKey    = "tstat"
+	zonesCacheKey    = "zones"
+	alarmsCacheKey   = "alarms"
+	linkCacheKey     = "link"
 )
 
 type Api struct {
 	ctx        context.Context
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+	closeOnce  sync.Once
 	Bus        *Bus
 	dispatcher *dispatcher.Dispatcher
+	// Field changes and bus traffic are published separately from cache
+	// updates
+	changes    *dispatcher.Dispatcher
+	frames     *dispatcher.Dispatcher
 	Cache      *cache.Cache
+	inventory  *inventory
+	alarms     *alarmHistory
+	tableState *tableState
 }
 
-func NewApi(ctx context.Context, device string) (*Api, error) {
-	bus, err := NewBus(device)
+func NewApi(ctx context.Context, device string, opts ...BusOption) (*Api, error) {
+	bus, err := NewBus(device, opts...)
 	if err != nil {
 		return nil, err
 	}
+	return NewApiWithBus(ctx, bus), nil
+}
 
+// NewApiWithBus creates an Api on top of an already opened bus.  The Api,
+// and the bus with it, are closed once ctx is done.
+func NewApiWithBus(ctx context.Context, bus *Bus) *Api {
+	ctx, cancel := context.WithCancel(ctx)
+	changes := dispatcher.New(ctx)
+	frames := dispatcher.New(ctx)
 	dispatcher := dispatcher.New(ctx)
 
 	cache := cache.New(dispatcher.BroadcastEvent)
 	// Set default values for structs the UI cares about
 	cache.Update(blowerCacheKey, &AirHandler{})
 	cache.Update(heatpumpCacheKey, &HeatPump{})
+	cache.Update(alarmsCacheKey, []Alarm{})
+	cache.Update(linkCacheKey, bus.Link())
 
 	api := &Api{
 		ctx:        ctx,
+		cancel:     cancel,
 		Bus:        bus,
 		dispatcher: dispatcher,
+		changes:    changes,
+		frames:     frames,
 		Cache:      cache,
+		inventory:  newInventory(),
+		alarms:     newAlarmHistory(),
+		tableState: newTableState(),
 	}
 	api.attachSnoops()
-	go api.poller()
-	return api, nil
+	api.wg.Add(2)
+	go func() {
+		defer api.wg.Done()
+		api.poller()
+	}()
+	go func() {
+		defer api.wg.Done()
+		api.identifier()
+	}()
+	go func() {
+		<-ctx.Done()
+		api.Close()
+	}()
+	return api
+}
+
+// Close stops polling, disconnects listeners and closes the bus, letting
+// requests already on the bus complete.
+func (a *Api) Close() error {
+	var err error
+	a.closeOnce.Do(func() {
+		a.cancel()
+		a.wg.Wait()
+		err = a.Bus.Close()
+	})
+	return err
 }
 
 func (a *Api) attachSnoops() {
+	a.Bus.OnLinkChange(func(status LinkStatus) {
+		a.Cache.Update(linkCacheKey, status)
+	})
+
+	a.Bus.Monitor(func(frame Frame) {
+		a.inventory.observe(frame, time.Now())
+	})
+
+	a.Bus.Monitor(func(frame Frame) {
+		a.frames.BroadcastEvent("frame", a.Bus.busFrame(frame, "received", time.Now()))
+	})
+	a.Bus.MonitorSent(func(frame Frame) {
+		a.frames.BroadcastEvent("frame", a.Bus.busFrame(frame, "sent", time.Now()))
+	})
+
+	a.Bus.Monitor(func(frame Frame) {
+		alarm, ok := decodeAlarm(frame, time.Now())
+		if !ok || !a.alarms.record(alarm) {
+			return
+		}
+		if alarm.Active {
+			log.Warnf("alarm raised by %s %s: %s (code %d)", alarm.Class, alarm.Device, alarm.Description, alarm.Code)
+		} else {
+			log.Infof("alarm cleared by %s %s: %s (code %d)", alarm.Class, alarm.Device, alarm.Description, alarm.Code)
+		}
+		a.dispatcher.BroadcastEvent("alarm", alarm)
+		a.Cache.Update(alarmsCacheKey, a.alarms.list(true))
+	})
+
+	// Decode tables carried by responses and by the thermostat's writes,
+	// publishing the fields that changed.  Writes from anyone else only carry
+	// the fields they change.
+	a.Bus.Monitor(func(frame Frame) {
+		if frame.op != Ack06 && !(frame.op == WriteTableBlock && frame.src == DevTSTAT) {
+			return
+		}
+		if len(frame.data) < 3 {
+			return
+		}
+		var addr TableAddr
+		copy(addr[:], frame.data[0:3])
+		decoded, err := a.Bus.definitions.decode(frame.src, addr, frame.data[3:])
+		if err != nil {
+			return
+		}
+
+		// Responses to our own requests are logged by whoever asked
+		if frame.dst != a.Bus.Address() {
+			log.Debugf("%s %s from %s: %s", decoded.Name, opToString(frame.op), decoded.Device, formatFields(decoded.Fields))
+		}
+		for _, change := range a.tableState.update(decoded, time.Now()) {
+			log.Debugf("field change: %s", change)
+			a.changes.BroadcastEvent("change", change)
+		}
+	})
+
 	// Snoop Heat Pump responses
 	a.Bus.SnoopResponse(filter(sourceRange(0x5000, 0x51ff), func(frame Frame) {
 		if heatPump, ok := a.GetHeatPump(); ok {
-			data := frame.data[3:]
-			if bytes.Equal(frame.data[0:3], []byte{0x00, 0x3e, 0x01}) {
-				heatPump.CoilTemp = float32(binary.BigEndian.Uint16(data[2:4])) / float32(16)
-				heatPump.OutsideTemp = float32(binary.BigEndian.Uint16(data[0:2])) / float32(16)
+			var temps HeatPumpTemperatures
+			var stage HeatPumpStage
+			if snoopTable(&frame, &temps) {
+				heatPump.CoilTemp = float32(temps.CoilTemp) / float32(16)
+				heatPump.OutsideTemp = float32(temps.OutsideTemp) / float32(16)
 				log.Debugf("heat pump coil temp is: %f", heatPump.CoilTemp)
 				log.Debugf("heat pump outside temp is: %f", heatPump.OutsideTemp)
-			} else if bytes.Equal(frame.data[0:3], []byte{0x00, 0x3e, 0x02}) {
-				heatPump.Stage = data[0] >> 1
+			} else if snoopTable(&frame, &stage) {
+				heatPump.Stage = stage.Stage >> 1
 				log.Debugf("HP stage is: %d", heatPump.Stage)
 			}
 			a.Cache.Update(heatpumpCacheKey, &heatPump)
@@ -69,13 +183,14 @@ func (a *Api) attachSnoops() {
 	// Snoop Air Handler responses
 	a.Bus.SnoopResponse(filter(sourceRange(0x4000, 0x42ff), func(frame Frame) {
 		if airHandler, ok := a.GetAirHandler(); ok {
-			data := frame.data[3:]
-			if bytes.Equal(frame.data[0:3], []byte{0x00, 0x03, 0x06}) {
-				airHandler.BlowerRPM = binary.BigEndian.Uint16(data[1:5])
+			var blower AirHandlerBlower
+			var airflow AirHandlerAirflow
+			if snoopTable(&frame, &blower) {
+				airHandler.BlowerRPM = blower.BlowerRPM
 				log.Debugf("blower RPM is: %d", airHandler.BlowerRPM)
-			} else if bytes.Equal(frame.data[0:3], []byte{0x00, 0x03, 0x16}) {
-				airHandler.AirFlowCFM = binary.BigEndian.Uint16(data[4:8])
-				airHandler.ElecHeat = data[0]&0x03 != 0
+			} else if snoopTable(&frame, &airflow) {
+				airHandler.AirFlowCFM = airflow.AirFlowCFM
+				airHandler.ElecHeat = airflow.State&0x03 != 0
 				log.Debugf("air flow CFM is: %d", airHandler.AirFlowCFM)
 			}
 			a.Cache.Update(blowerCacheKey, &airHandler)
@@ -83,6 +198,20 @@ func (a *Api) attachSnoops() {
 	}))
 }
 
+// snoopTable decodes table from a response seen on the bus, reporting
+// whether the response carried it.
+func snoopTable(frame *Frame, table Table) bool {
+	addr := table.addr()
+	if len(frame.data) < 3 || !bytes.Equal(frame.data[0:3], addr[:]) {
+		return false
+	}
+	if err := decodeResponse(frame, table); err != nil {
+		log.Debugf("error decoding %s: %v", LayoutOf(table).Name, err)
+		return false
+	}
+	return true
+}
+
 func (a *Api) poller() {
 	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
@@ -90,8 +219,56 @@ func (a *Api) poller() {
 	for {
 		select {
 		case <-ticker.C:
-			if c, ok := a.GetConfig(1); ok {
-				a.Cache.Update(tstatCacheKey, c)
+			a.pollZones()
+		case <-a.ctx.Done():
+			return
+		}
+	}
+}
+
+// pollZones refreshes the state of every enabled zone.  Zone 1 is also kept
+// under its old key for clients that predate multi-zone support.
+func (a *Api) pollZones() {
+	cfg, params, err := a.readZoneTables(a.ctx, priorityPoll)
+	if err != nil {
+		return
+	}
+
+	zones := enabledZones(cfg, params)
+	for _, z := range zones {
+		a.Cache.Update(zoneCacheKey(z.Zone), zoneConfig(z.Zone, cfg, params))
+	}
+	a.Cache.Update(tstatCacheKey, zoneConfig(1, cfg, params))
+	a.Cache.Update(zonesCacheKey, zones)
+}
+
+func zoneCacheKey(zone int) string {
+	return fmt.Sprintf("zone/%d", zone)
+}
+
+// identifier reads the identification table of every device discovered on
+// the bus.
+func (a *Api) identifier() {
+	ticker := time.NewTicker(identifyInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, addr := range a.inventory.unidentified(a.Bus.Address(), time.Now()) {
+				params := DeviceInfoParams{}
+				err := a.Bus.readTable(a.ctx, addr, &params, priorityPoll)
+				switch {
+				case err == nil:
+					info := params.ToAPI()
+					log.Infof("identified device %04x: %+v", addr, *info)
+					a.inventory.identified(addr, info)
+				case errors.Is(err, ErrNotSeen):
+					// A passive bus learns the table once someone else reads it
+				default:
+					log.Debugf("error identifying device %04x: %s", addr, err.Error())
+					a.inventory.failed(addr, time.Now())
+				}
 			}
 		case <-a.ctx.Done():
 			return
@@ -99,10 +276,17 @@ func (a *Api) poller() {
 	}
 }
 
+// Devices lists every address seen on the bus.
+func (a *Api) Devices() []Device {
+	return a.inventory.list()
+}
+
 type TStatZoneConfig struct {
+	Name            string `json:"name"`
 	TempUnit        string `json:"tempUnit"`
 	CurrentTemp     uint8  `json:"currentTemp"`
 	CurrentHumidity uint8  `json:"currentHumidity"`
+	TargetHumidity  uint8  `json:"targetHumidity"`
 	OutdoorTemp     int8   `json:"outdoorTemp"`
 	Mode            string `json:"mode"`
 	Stage           uint8  `json:"stage"`
@@ -127,41 +311,100 @@ type HeatPump struct {
 }
 
 func (a *Api) GetConfig(zone int) (*TStatZoneConfig, bool) {
+	cfg, err := a.GetConfigContext(context.Background(), zone)
+	return cfg, err == nil
+}
+
+func (a *Api) GetConfigContext(ctx context.Context, zone int) (*TStatZoneConfig, error) {
+	return a.getConfig(ctx, zone, priorityRead)
+}
+
+func (a *Api) getConfig(ctx context.Context, zone int, prio priority) (*TStatZoneConfig, error) {
+	if zone < 1 || zone > 8 {
+		return nil, invalidArgument("zone %d out of range", zone)
+	}
+
+	cfg, params, err := a.readZoneTables(ctx, prio)
+	if err != nil {
+		return nil, err
+	}
+	return zoneConfig(zone, cfg, params), nil
+}
+
+// readZoneTables reads the thermostat's tables holding the state of every
+// zone.
+func (a *Api) readZoneTables(ctx context.Context, prio priority) (*TStatZoneParams, *TStatCurrentParams, error) {
 	cfg := TStatZoneParams{}
-	ok := a.Bus.ReadTable(DevTSTAT, &cfg)
-	if !ok {
-		return nil, false
+	if err := a.Bus.readTable(ctx, DevTSTAT, &cfg, prio); err != nil {
+		return nil, nil, err
 	}
 
 	params := TStatCurrentParams{}
-	ok = a.Bus.ReadTable(DevTSTAT, &params)
-	if !ok {
-		return nil, false
+	if err := a.Bus.readTable(ctx, DevTSTAT, &params, prio); err != nil {
+		return nil, nil, err
+	}
+	return &cfg, &params, nil
+}
+
+// Zone is a zone the thermostat controls.
+type Zone struct {
+	Zone int    `json:"zone"`
+	Name string `json:"name"`
+}
+
+// GetZonesContext lists the enabled zones.
+func (a *Api) GetZonesContext(ctx context.Context) ([]Zone, error) {
+	cfg, params, err := a.readZoneTables(ctx, priorityRead)
+	if err != nil {
+		return nil, err
+	}
+	return enabledZones(cfg, params), nil
+}
+
+// enabledZones lists the zones with a temperature sensor.  The thermostat
+// reports a temperature of 0 for zones that aren't installed, and zone 1 is
+// always there.
+func enabledZones(cfg *TStatZoneParams, params *TStatCurrentParams) []Zone {
+	zones := []Zone{}
+	for i := range params.CurrentTemp {
+		if i == 0 || params.CurrentTemp[i] != 0 {
+			zones = append(zones, Zone{Zone: i + 1, Name: trimString(cfg.Name[i][:])})
+		}
 	}
+	return zones
+}
 
+func zoneConfig(zone int, cfg *TStatZoneParams, params *TStatCurrentParams) *TStatZoneConfig {
 	hold := new(bool)
-	*hold = cfg.ZoneHold&(1<<zone-1) != 0
+	*hold = cfg.ZoneHold&(1<<(zone-1)) != 0
 
 	return &TStatZoneConfig{
-		CurrentTemp:     params.GetZonalField(zone, "CurrentTemp").(uint8),
-		CurrentHumidity: params.GetZonalField(zone, "CurrentHumidity").(uint8),
+		Name:            trimString(cfg.Name[zone-1][:]),
+		CurrentTemp:     params.CurrentTemp[zone-1],
+		CurrentHumidity: params.CurrentHumidity[zone-1],
+		TargetHumidity:  cfg.TargetHumidity[zone-1],
 		OutdoorTemp:     params.OutdoorAirTemp,
 		Mode:            RawModeToString(params.Mode & 0xf),
 		Stage:           params.Mode >> 5,
-		FanMode:         RawFanModeToString(cfg.GetZonalField(zone, "FanMode").(uint8)),
+		FanMode:         RawFanModeToString(cfg.FanMode[zone-1]),
 		Hold:            hold,
-		HeatSetpoint:    cfg.GetZonalField(zone, "HeatSetpoint").(uint8),
-		CoolSetpoint:    cfg.GetZonalField(zone, "CoolSetpoint").(uint8),
+		HeatSetpoint:    cfg.HeatSetpoint[zone-1],
+		CoolSetpoint:    cfg.CoolSetpoint[zone-1],
 		RawMode:         params.Mode,
-	}, true
+	}
 }
 
 func (a *Api) GetTstatSettings() (*TStatSettings, bool) {
+	tss, err := a.GetTstatSettingsContext(context.Background())
+	return tss, err == nil
+}
+
+func (a *Api) GetTstatSettingsContext(ctx context.Context) (*TStatSettings, error) {
 	tss := TStatSettings{}
-	if !a.Bus.ReadTable(DevTSTAT, &tss) {
-		return nil, false
+	if err := a.Bus.ReadTableContext(ctx, DevTSTAT, &tss); err != nil {
+		return nil, err
 	}
-	return &tss, true
+	return &tss, nil
 }
 
 func (a *Api) GetAirHandler() (AirHandler, bool) {
@@ -183,20 +426,218 @@ func (a *Api) GetHeatPump() (HeatPump, bool) {
 }
 
 func (a *Api) GetTableRaw(deviceAddr uint16, table []byte) []byte {
+	data, _ := a.GetTableRawContext(context.Background(), deviceAddr, table)
+	return data
+}
+
+func (a *Api) GetTableRawContext(ctx context.Context, deviceAddr uint16, table []byte) ([]byte, error) {
+	return a.readTableRaw(ctx, deviceAddr, table, false)
+}
+
+// GetTableBodyContext reads a table from a device like GetTableRawContext,
+// but returns everything the response carries after the table address: the
+// 3 byte header most tables have, then the table.
+func (a *Api) GetTableBodyContext(ctx context.Context, deviceAddr uint16, table []byte) ([]byte, error) {
+	return a.readTableRaw(ctx, deviceAddr, table, true)
+}
+
+func (a *Api) readTableRaw(ctx context.Context, deviceAddr uint16, table []byte, header bool) ([]byte, error) {
+	if len(table) != 3 {
+		return nil, invalidArgument("table address must be 3 bytes")
+	}
+
 	var addr TableAddr
 	copy(addr[:], table[0:3])
-	raw := rawRequest{Data: &[]byte{}}
+	raw := rawRequest{Data: &[]byte{}, Header: header}
 
-	if a.Bus.Read(uint16(deviceAddr), addr, raw) {
-		return *raw.Data
+	if err := a.Bus.ReadContext(ctx, deviceAddr, addr, raw); err != nil {
+		return nil, err
 	}
-	return nil
+	return *raw.Data, nil
+}
+
+// RawWrite describes a write of raw bytes to a table, and the table before
+// and after it.
+type RawWrite struct {
+	Device string `json:"device"`
+	Table  string `json:"table"`
+	// The 3 bytes written between the table address and the data, holding
+	// the write flags
+	Prefix string `json:"prefix"`
+	Data   string `json:"data"`
+	// The frame sent, or that would have been sent by a dry run
+	Frame  string `json:"frame"`
+	DryRun bool   `json:"dryRun"`
+	Before string `json:"before"`
+	After  string `json:"after,omitempty"`
+	// Why the table couldn't be read back after the write
+	AfterError string `json:"afterError,omitempty"`
+}
+
+// WriteTableRawContext writes raw bytes to a table of a device, reading the
+// table before and after the write.  A dry run only reads the table and
+// reports the frame that would have been sent.
+func (a *Api) WriteTableRawContext(ctx context.Context, deviceAddr uint16, table []byte, prefix []byte, data []byte, dryRun bool) (*RawWrite, error) {
+	if len(table) != 3 || len(prefix) != 3 {
+		return nil, invalidArgument("table and prefix must be 3 bytes")
+	}
+	if len(data) == 0 || len(data) > maxFrameData-6 {
+		return nil, invalidArgument("data must be 1 to %d bytes", maxFrameData-6)
+	}
+	if !dryRun && a.Bus.Passive() {
+		return nil, ErrReadOnly
+	}
+
+	payload := append(append(append([]byte{}, table...), prefix...), data...)
+	w := &RawWrite{
+		Device: fmt.Sprintf("%04x", deviceAddr),
+		Table:  hex.EncodeToString(table),
+		Prefix: hex.EncodeToString(prefix),
+		Data:   hex.EncodeToString(data),
+		Frame:  hex.EncodeToString(NewFrame(a.Bus.Address(), deviceAddr, WriteTableBlock, payload).Encode()),
+		DryRun: dryRun,
+	}
+
+	// Don't write a table we can't put back the way it was
+	before, err := a.GetTableRawContext(ctx, deviceAddr, table)
+	if err != nil {
+		return nil, fmt.Errorf("reading table before writing it: %w", err)
+	}
+	w.Before = hex.EncodeToString(before)
+	if dryRun {
+		return w, nil
+	}
+
+	if err := a.Bus.WriteContext(ctx, deviceAddr, table, prefix, data); err != nil {
+		return nil, err
+	}
+
+	after, err := a.GetTableRawContext(ctx, deviceAddr, table)
+	if err != nil {
+		w.AfterError = err.Error()
+	} else {
+		w.After = hex.EncodeToString(after)
+	}
+	return w, nil
+}
+
+// RequestRawContext sends a request with an arbitrary operation to a device
+// and returns the data carried by its response.
+func (a *Api) RequestRawContext(ctx context.Context, deviceAddr uint16, op uint8, data []byte) ([]byte, error) {
+	return a.Bus.RequestContext(ctx, deviceAddr, op, data)
+}
+
+func (a *Api) ReadVariableContext(ctx context.Context, deviceAddr uint16, addr []byte) ([]byte, error) {
+	return a.Bus.ReadVariableContext(ctx, deviceAddr, addr)
+}
+
+func (a *Api) ForceVariableContext(ctx context.Context, deviceAddr uint16, addr []byte, value []byte) error {
+	return a.Bus.ForceVariableContext(ctx, deviceAddr, addr, value)
+}
+
+func (a *Api) AutoVariableContext(ctx context.Context, deviceAddr uint16, addr []byte) error {
+	return a.Bus.AutoVariableContext(ctx, deviceAddr, addr)
+}
+
+func (a *Api) ReadObjectDataContext(ctx context.Context, deviceAddr uint16, object []byte) ([]byte, error) {
+	return a.Bus.ReadObjectDataContext(ctx, deviceAddr, object)
+}
+
+func (a *Api) ReadListContext(ctx context.Context, deviceAddr uint16, list []byte) ([]byte, error) {
+	return a.Bus.ReadListContext(ctx, deviceAddr, list)
+}
+
+func (a *Api) ChangeTableNameContext(ctx context.Context, deviceAddr uint16, data []byte) error {
+	return a.Bus.ChangeTableNameContext(ctx, deviceAddr, data)
 }
 
 func (a *Api) UpdateThermostat(table Table, flags uint8) bool {
-	return a.Bus.WriteTable(DevTSTAT, table, flags)
+	return a.UpdateThermostatContext(context.Background(), table, flags) == nil
+}
+
+func (a *Api) UpdateThermostatContext(ctx context.Context, table Table, flags uint8) error {
+	return a.Bus.WriteTableContext(ctx, DevTSTAT, table, flags)
+}
+
+// LoadTableDefinitions loads table definitions from a file, or from every
+// definitions file in a directory, to decode tables with.
+func (a *Api) LoadTableDefinitions(path string) error {
+	n, err := a.Bus.definitions.load(path)
+	if err != nil {
+		return err
+	}
+	log.Infof("loaded %d table definitions from %s", n, path)
+	return nil
+}
+
+// DecodeTable decodes table on device into named fields from body, the data
+// following the table address in a frame, as returned by
+// GetTableBodyContext.  ErrNoDefinition is returned if the table isn't known.
+func (a *Api) DecodeTable(deviceAddr uint16, table []byte, body []byte) (*DecodedTable, error) {
+	if len(table) != 3 {
+		return nil, invalidArgument("table address must be 3 bytes")
+	}
+	var addr TableAddr
+	copy(addr[:], table)
+	return a.Bus.definitions.decode(deviceAddr, addr, body)
+}
+
+// GetTableDecodedContext reads a table from a device and decodes it into
+// named fields.
+func (a *Api) GetTableDecodedContext(ctx context.Context, deviceAddr uint16, table []byte) (*DecodedTable, error) {
+	if len(table) != 3 {
+		return nil, invalidArgument("table address must be 3 bytes")
+	}
+	var addr TableAddr
+	copy(addr[:], table)
+	if _, ok := a.Bus.definitions.lookup(deviceAddr, addr); !ok {
+		return nil, ErrNoDefinition
+	}
+
+	body, err := a.GetTableBodyContext(ctx, deviceAddr, table)
+	if err != nil {
+		return nil, err
+	}
+	return a.Bus.definitions.decode(deviceAddr, addr, body)
+}
+
+// PersistAlarms loads the alarm history saved at path, if any, and keeps
+// saving it there.
+func (a *Api) PersistAlarms(path string) error {
+	if err := a.alarms.persist(path); err != nil {
+		return err
+	}
+	a.Cache.Update(alarmsCacheKey, a.alarms.list(true))
+	return nil
+}
+
+// Alarms returns the alarms raised and cleared on the bus, newest first,
+// optionally only those still active.
+func (a *Api) Alarms(activeOnly bool) []Alarm {
+	return a.alarms.list(activeOnly)
 }
 
 func (a *Api) NewListener() *dispatcher.Listener {
 	return a.dispatcher.NewListener()
 }
+
+// NewChangeListener returns a listener receiving a FieldChange event for
+// every change to a field of a decoded table seen on the bus.
+func (a *Api) NewChangeListener() *dispatcher.Listener {
+	return a.changes.NewListener()
+}
+
+// NewFrameListener returns a listener receiving a BusFrame event for every
+// frame read from or sent on the bus.
+func (a *Api) NewFrameListener() *dispatcher.Listener {
+	return a.frames.NewListener()
+}
+
+// formatFields formats decoded fields for logging.
+func formatFields(fields []FieldValue) string {
+	parts := make([]string, len(fields))
+	for i, f := range fields {
+		parts[i] = fmt.Sprintf("%s=%v", f.Name, f.Value)
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,95 @@
+package infinity_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/acd/infinitive/infinity"
+	"github.com/acd/infinitive/infinity/bustest"
+)
+
+func newTestApi(t *testing.T, fb *bustest.Bus) *infinity.Api {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	api, err := bustest.NewApi(ctx, fb)
+	if err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		api.Close()
+	})
+	return api
+}
+
+func TestConfigRoundTrip(t *testing.T) {
+	fb := bustest.NewBus()
+	fb.Thermostat.SetTable(&infinity.TStatZoneParams{
+		HeatSetpoint: [8]uint8{68, 66},
+		CoolSetpoint: [8]uint8{74, 76},
+		ZoneHold:     0x02,
+	})
+	fb.Thermostat.SetTable(&infinity.TStatCurrentParams{CurrentTemp: [8]uint8{70, 71}})
+	api := newTestApi(t, fb)
+	ctx := context.Background()
+
+	cfg, err := api.GetConfigContext(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.CurrentTemp != 71 || cfg.HeatSetpoint != 66 || cfg.CoolSetpoint != 76 || !*cfg.Hold {
+		t.Fatalf("zone 2 config = %+v", cfg)
+	}
+
+	params := infinity.TStatZoneParams{HeatSetpoint: [8]uint8{68, 64}}
+	flags := infinity.LayoutOf(params).Flags("HeatSetpoint")
+	if err := api.UpdateThermostatContext(ctx, params, flags); err != nil {
+		t.Fatal(err)
+	}
+
+	writes := fb.Thermostat.Writes()
+	if len(writes) != 1 {
+		t.Fatalf("got %d writes, want 1", len(writes))
+	}
+	if w := writes[0]; w.Table != infinity.AddrOf(params) || w.Flags != 0x04 {
+		t.Fatalf("wrote table %x with flags %02x, want %x with 04", w.Table, w.Flags, infinity.AddrOf(params))
+	}
+
+	cfg, err = api.GetConfigContext(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the flagged field may change
+	if cfg.HeatSetpoint != 64 || cfg.CoolSetpoint != 76 || !*cfg.Hold {
+		t.Fatalf("zone 2 config after write = %+v", cfg)
+	}
+}
+
+func TestChangeTableNameIsSent(t *testing.T) {
+	fb := bustest.NewBus()
+	api := newTestApi(t, fb)
+
+	sent := make(chan infinity.Frame, 10)
+	api.Bus.MonitorSent(func(f infinity.Frame) {
+		if f.Op() == infinity.ChangeTableName {
+			sent <- f
+		}
+	})
+
+	// The fake thermostat doesn't support CHGTBN, so the request comes back
+	// rejected
+	err := api.ChangeTableNameContext(context.Background(), infinity.DevTSTAT, []byte{0x00, 0x3b, 0x03})
+	var nack *infinity.NackError
+	if !errors.As(err, &nack) || nack.Op != infinity.ChangeTableName || nack.Code != infinity.NackUnsupportedOp {
+		t.Fatalf("got error %v, want a NACK of CHGTBN", err)
+	}
+	if len(sent) != 1 {
+		t.Fatalf("CHGTBN sent %d times, want once", len(sent))
+	}
+	if f := <-sent; f.Dst() != infinity.DevTSTAT || !bytes.Equal(f.Data(), []byte{0x00, 0x3b, 0x03}) {
+		t.Errorf("sent %v", f)
+	}
+}
@@ -2,91 +2,308 @@ package infinity
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
-	"github.com/tarm/serial"
 )
 
 const (
 	DevTSTAT = uint16(0x2001)
-	DevSAM   = uint16(0x9201)
+	// DevSAM is the address we use on the bus unless told otherwise
+	DevSAM = uint16(0x9201)
 )
 
 const responseTimeout = 200 * time.Millisecond
 const responseRetries = 5
+const reopenDelay = time.Second
+
+// How long a single read of the port waits for data.  Silence is detected by
+// the watchdog, this only bounds how long closing the port may take.
+const readTimeout = time.Second
 
 type rawRequest struct {
 	Data *[]byte
+	// Whether Data also gets the header between the table address and the
+	// table
+	Header bool
 }
 
-// For future use in unit tests
-type port interface {
+// Port is a connection to the ABCD bus.
+type Port interface {
 	Read(b []byte) (n int, err error)
 	Write(b []byte) (n int, err error)
 	Close() error
 }
 
+// PortOpener opens a connection to the bus.  It is called again whenever the
+// connection has to be reestablished after an error.
+type PortOpener func() (Port, error)
+
 type Bus struct {
-	device      string
-	readTimeout time.Duration
-	port        port
-	responseCh  chan Frame
-	actionCh    chan *Action
-	snoops      []frameHandler
-	mu          sync.Mutex
+	device       string
+	addr         uint16
+	readTimeout  time.Duration
+	open         PortOpener
+	port         Port
+	portMu       sync.Mutex
+	responseCh   chan Frame
+	scheduler    *scheduler
+	snoops       []frameHandler
+	monitors     []frameHandler
+	sentMonitors []frameHandler
+	mu           sync.Mutex
+	capture      *Capture
+	passive      atomic.Bool
+	tables       map[uint16]map[TableAddr][]byte
+	tablesMu     sync.Mutex
+	definitions  *tableRegistry
+	// Whether frames are logged with their dissection
+	dissect bool
+	stats   *busStats
+	timing  *timing
+	link    *link
+	// How long to listen for another device using our address before
+	// transmitting, and whether to fall back to passive mode if one does
+	conflictWatch    time.Duration
+	conflictFallback bool
+	conflictCh       chan uint16
+
+	closed     atomic.Bool
+	closeOnce  sync.Once
+	done       chan struct{}
+	readerDone chan struct{}
+	brokerDone chan struct{}
+}
+
+// BusOption customizes a Bus created by NewBus.
+type BusOption func(*Bus)
+
+// WithPortOpener connects the bus through open instead of opening device as a
+// serial port or network bridge.
+func WithPortOpener(open PortOpener) BusOption {
+	return func(b *Bus) {
+		b.open = open
+	}
+}
+
+// WithPassive puts the bus in listen-only mode.  A passive bus never
+// transmits: it doesn't poll or acknowledge the thermostat, reads are answered
+// from the most recent copy of each table seen on the bus, and writes fail.
+func WithPassive() BusOption {
+	return func(b *Bus) {
+		b.passive.Store(true)
+	}
+}
+
+// WithAddress sets the address the bus transmits from, DevSAM by default.
+func WithAddress(addr uint16) BusOption {
+	return func(b *Bus) {
+		b.addr = addr
+	}
 }
 
-func NewBus(device string) (*Bus, error) {
+// WithConflictWatch makes NewBus listen for d before transmitting anything,
+// watching for some other device already using our address.  If one shows up
+// NewBus fails with ErrAddressConflict, or if fallback is set, the bus stays
+// passive instead.
+func WithConflictWatch(d time.Duration, fallback bool) BusOption {
+	return func(b *Bus) {
+		b.conflictWatch = d
+		b.conflictFallback = fallback
+	}
+}
+
+func NewBus(device string, opts ...BusOption) (*Bus, error) {
 	b := &Bus{
 		device:      device,
-		readTimeout: time.Second * 5,
+		addr:        DevSAM,
+		readTimeout: readTimeout,
 		responseCh:  make(chan Frame, 32),
-		actionCh:    make(chan *Action),
+		scheduler:   newScheduler(),
+		tables:      make(map[uint16]map[TableAddr][]byte),
+		definitions: newTableRegistry(),
+		stats:       newBusStats(),
+		timing:      newTiming(),
+		link:        newLink(),
+		conflictCh:  make(chan uint16, 1),
+		done:        make(chan struct{}),
+		readerDone:  make(chan struct{}),
+		brokerDone:  make(chan struct{}),
 	}
-	if err := b.openSerial(); err != nil {
+	b.open = func() (Port, error) {
+		return openTransport(b.device, b.readTimeout)
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	b.timing.self = b.addr
+	b.link.device = b.device
+
+	if err := b.openPort(); err != nil {
 		return nil, err
 	}
 
+	// Stay quiet while watching for conflicts
+	watch := b.conflictWatch > 0 && !b.passive.Load()
+	if watch {
+		b.passive.Store(true)
+	}
+
 	go b.reader()
 	go b.broker()
+	go b.watchdog()
+
+	if watch {
+		if err := b.watchForConflict(); err != nil {
+			if !b.conflictFallback {
+				b.Close()
+				return nil, err
+			}
+			log.Errorf("%s, staying in passive mode", err.Error())
+		} else {
+			b.passive.Store(false)
+		}
+	}
 
 	return b, nil
 }
 
+// watchForConflict listens to the bus for conflictWatch, reporting an error if
+// a frame is sent from our address.
+func (b *Bus) watchForConflict() error {
+	log.Infof("watching the bus for %s before transmitting as %04x", b.conflictWatch, b.addr)
+	timer := time.NewTimer(b.conflictWatch)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case dst := <-b.conflictCh:
+		return fmt.Errorf("%w: a frame from %04x to %04x was seen on the bus", ErrAddressConflict, b.addr, dst)
+	}
+}
+
 type Action struct {
+	ctx           context.Context
 	requestFrame  Frame
 	responseFrame *Frame
-	ok            bool
-	ch            chan bool
+	priority      priority
+	deadline      time.Time
+	ch            chan error
+}
+
+// Passive reports whether the bus is in listen-only mode.
+func (b *Bus) Passive() bool {
+	return b.passive.Load()
+}
+
+// Address returns the address the bus transmits from.
+func (b *Bus) Address() uint16 {
+	return b.addr
+}
+
+// Close stops the bus.  Requests already being sent are allowed to complete,
+// queued ones fail with ErrClosed, and the port is closed once nothing is
+// being transmitted.
+func (b *Bus) Close() error {
+	b.closeOnce.Do(func() {
+		log.Printf("closing bus interface: %s", b.device)
+		b.closed.Store(true)
+		close(b.done)
+		for _, action := range b.scheduler.close() {
+			action.ch <- ErrClosed
+		}
+		<-b.brokerDone
+
+		b.portMu.Lock()
+		if b.port != nil {
+			b.port.Close()
+			b.port = nil
+		}
+		b.portMu.Unlock()
+		<-b.readerDone
+	})
+	return nil
 }
 
-func (b *Bus) openSerial() error {
-	log.Printf("opening serial interface: %s", b.device)
+func (b *Bus) openPort() error {
+	b.portMu.Lock()
+	defer b.portMu.Unlock()
+
+	if b.closed.Load() {
+		return ErrClosed
+	}
+
+	log.Printf("opening bus interface: %s", b.device)
 	if b.port != nil {
 		b.port.Close()
 		b.port = nil
 	}
 
-	c := &serial.Config{
-		Name:        b.device,
-		Baud:        38400,
-		ReadTimeout: b.readTimeout,
+	p, err := b.open()
+	if err != nil {
+		return err
+	}
+	b.port = p
+	b.link.opened(time.Now())
+	return nil
+}
+
+// currentPort returns the open port, or nil if it has to be reopened.
+func (b *Bus) currentPort() Port {
+	b.portMu.Lock()
+	defer b.portMu.Unlock()
+	return b.port
+}
+
+// closePort closes p after an error, unless it was already replaced.
+func (b *Bus) closePort(p Port) {
+	b.portMu.Lock()
+	defer b.portMu.Unlock()
+
+	if b.port == p {
+		p.Close()
+		b.port = nil
 	}
-	var err error
-	b.port, err = serial.OpenPort(c)
-	return err
 }
 
 func (b *Bus) handleFrame(frame Frame) *Frame {
-	log.Printf("read frame: %s", frame)
+	log.Printf("read frame: %s", b.describe(frame))
+
+	b.mu.Lock()
+	for _, monitor := range b.monitors {
+		monitor(frame)
+	}
+	b.mu.Unlock()
 
+	// While watching for conflicts we send nothing, so a frame from our
+	// address was sent by someone else.
+	if frame.src == b.addr {
+		select {
+		case b.conflictCh <- frame.dst:
+		default:
+		}
+	}
+
+	passive := b.passive.Load()
 	switch frame.op {
+	case Nack:
+		if frame.dst == b.addr && !passive {
+			b.respond(frame)
+		}
 	case Ack06:
-		if frame.dst == DevSAM {
-			b.responseCh <- frame
+		if frame.dst == b.addr && !passive {
+			b.respond(frame)
+		}
+
+		if passive {
+			b.snapshot(frame)
 		}
 
 		if len(frame.data) > 3 {
@@ -97,35 +314,88 @@ func (b *Bus) handleFrame(frame Frame) *Frame {
 			}
 		}
 	case WriteTableBlock:
-		if frame.src == DevTSTAT && frame.dst == DevSAM {
-			return &writeAck
+		if passive {
+			if frame.src == DevTSTAT {
+				b.snapshot(frame)
+			}
+		} else if frame.src == DevTSTAT && frame.dst == b.addr {
+			return &Frame{src: b.addr, dst: DevTSTAT, op: Ack06, data: []byte{0x00}}
 		}
 	}
 
 	return nil
 }
 
+// respond hands a response to the broker.  Responses nobody is waiting for
+// are dropped rather than blocking the reader.
+func (b *Bus) respond(frame Frame) {
+	select {
+	case b.responseCh <- frame:
+	default:
+		log.Printf("dropping unexpected response: %s", frame)
+	}
+}
+
 func (b *Bus) reader() {
-	defer panic("exiting InfinityProtocol reader, this should never happen")
+	defer close(b.readerDone)
 
 	msg := []byte{}
 	buf := make([]byte, 1024)
+	// Bytes skipped while resynchronizing, recorded as one run once the next
+	// frame decodes or the port is reopened.
+	rejected := []byte{}
 
 	for {
-		if b.port == nil {
+		port := b.currentPort()
+		if port == nil {
+			rejected = append(rejected, msg...)
+			b.stats.discarded(len(rejected))
+			b.record(true, rejected, true)
+			rejected = rejected[:0]
 			msg = []byte{}
-			b.openSerial()
+			// Back off after repeated failures
+			if d := b.link.backoff(); d > 0 {
+				timer := time.NewTimer(d)
+				select {
+				case <-timer.C:
+				case <-b.done:
+					timer.Stop()
+				}
+			}
+			if b.closed.Load() {
+				return
+			}
+
+			b.link.reopening(time.Now())
+			err := b.openPort()
+			if errors.Is(err, ErrClosed) {
+				return
+			}
+			b.stats.reopen(err)
+			if err != nil {
+				log.Errorf("error opening %s: %s", b.device, err.Error())
+				b.link.openFailed(err, time.Now())
+				continue
+			}
+			port = b.currentPort()
 		}
 
-		n, err := b.port.Read(buf)
-		if n == 0 || err != nil {
-			log.Printf("error reading from serial port: %s", err.Error())
-			if b.port != nil {
-				b.port.Close()
+		n, err := port.Read(buf)
+		if err != nil {
+			if !b.closed.Load() {
+				log.Printf("error reading from bus: %v", err)
+				b.link.lost(LinkReopening, err, time.Now())
 			}
-			b.port = nil
+			b.closePort(port)
 			continue
 		}
+		if n == 0 {
+			// Read timed out, the watchdog decides when silence is a problem
+			continue
+		}
+
+		b.timing.received(time.Now())
+		b.link.received(time.Now())
 
 		// log.Printf("%q", buf[:n])
 		msg = append(msg, buf[:n]...)
@@ -143,6 +413,14 @@ func (b *Bus) reader() {
 
 			frame := Frame{}
 			if frame.decode(buf) {
+				b.stats.discarded(len(rejected))
+				b.record(true, rejected, true)
+				rejected = rejected[:0]
+				b.record(true, buf, false)
+				now := time.Now()
+				b.stats.frame(frame, now)
+				b.timing.observe(frame, now)
+
 				if response := b.handleFrame(frame); response != nil {
 					b.sendFrame(response.encode())
 				}
@@ -152,6 +430,8 @@ func (b *Bus) reader() {
 				msg = msg[:copy(msg, msg[l:])]
 			} else {
 				// Corrupt message, move ahead one byte and continue parsing
+				b.stats.checksumFailure()
+				rejected = append(rejected, msg[0])
 				msg = msg[:copy(msg, msg[1:])]
 			}
 		}
@@ -159,116 +439,360 @@ func (b *Bus) reader() {
 }
 
 func (b *Bus) broker() {
-	defer panic("exiting InfinityProtocol broker, this should never happen")
+	defer close(b.brokerDone)
 
-	for action := range b.actionCh {
+	for {
+		action := b.scheduler.pop()
+		if action == nil {
+			return
+		}
+		if err := action.ctx.Err(); err != nil {
+			action.ch <- err
+			continue
+		}
+		if time.Now().After(action.deadline) {
+			log.Printf("action expired before it could be sent: %s", action.requestFrame)
+			b.stats.timeout(action.requestFrame.dst)
+			action.ch <- ErrTimeout
+			continue
+		}
 		b.performAction(action)
 	}
 }
 
 func (b *Bus) performAction(action *Action) {
-	log.Infof("encoded frame: %s", action.requestFrame)
+	// Discard responses to earlier requests that arrived after they gave up,
+	// so they can't be mistaken for responses to this one.
+	for len(b.responseCh) > 0 {
+		<-b.responseCh
+	}
+
+	log.Infof("encoded frame: %s", b.describe(action.requestFrame))
 	encodedFrame := action.requestFrame.encode()
-	b.sendFrame(encodedFrame)
+	if err := b.timing.waitQuiet(action.ctx); err != nil {
+		action.ch <- err
+		return
+	}
+	sent := b.sendFrame(encodedFrame)
+	start := time.Now()
 
-	ticker := time.NewTicker(responseTimeout)
-	defer ticker.Stop()
+	timer := time.NewTimer(retryDelay(0))
+	defer timer.Stop()
 
-	for tries := 0; tries < responseRetries; {
+	tries := 0
+loop:
+	for time.Now().Before(action.deadline) {
 		select {
 		case res := <-b.responseCh:
 			if res.src != action.requestFrame.dst {
 				continue
 			}
 
-			reqTable := action.requestFrame.data[0:3]
-			resTable := res.data[0:3]
+			// A device rejecting the request will keep rejecting it, so
+			// there is no point retrying.
+			if res.op == Nack {
+				err := nackError(action.requestFrame, res)
+				log.Warn(err.Error())
+				action.ch <- err
+				return
+			}
 
-			if action.requestFrame.op == ReadTableBlock && !bytes.Equal(reqTable, resTable) {
-				log.Printf("got response for incorrect table, is: %x expected: %x", resTable, reqTable)
+			if !matchesRequest(action.requestFrame, res) {
+				log.Printf("got response for a different request, is: %x expected: %x", res.data, action.requestFrame.data)
 				continue
 			}
 
+			b.stats.response(res.src, time.Since(start))
 			action.responseFrame = &res
-			// log.Printf("got response!")
-			action.ok = true
-			action.ch <- true
-			// log.Printf("sent action!")
+			action.ch <- nil
 			return
-		case <-ticker.C:
-			log.Debug("timeout waiting for response, retransmitting frame")
-			b.sendFrame(encodedFrame)
+		case <-timer.C:
+			if tries == responseRetries {
+				break loop
+			}
 			tries++
+
+			// If we were cancelled or a response arrived while waiting for
+			// the bus, the next iteration handles it instead.
+			if b.timing.waitQuiet(action.ctx) == nil && len(b.responseCh) == 0 {
+				log.Debug("timeout waiting for response, retransmitting frame")
+				if b.sendFrame(encodedFrame) {
+					sent = true
+				}
+				b.stats.retransmit(action.requestFrame.dst)
+			}
+			timer.Reset(retryDelay(tries))
+		case <-action.ctx.Done():
+			action.ch <- action.ctx.Err()
+			return
 		}
 	}
 
 	log.Printf("action timed out")
-	action.ch <- false
+	b.stats.timeout(action.requestFrame.dst)
+	if !sent {
+		action.ch <- ErrPortDown
+	} else {
+		action.ch <- ErrTimeout
+	}
+}
+
+// responseEcho is how many leading bytes of a request the response to it
+// repeats, which tells responses to different requests apart.  Responses to
+// other operations can only be matched by their source.
+var responseEcho = map[uint8]int{
+	ReadTableBlock: 3,
+}
+
+// matchesRequest reports whether res, an Ack06 from the addressed device, is
+// the response to req.
+func matchesRequest(req Frame, res Frame) bool {
+	n := responseEcho[req.op]
+	return len(res.data) >= n && len(req.data) >= n && bytes.Equal(req.data[:n], res.data[:n])
 }
 
-func (b *Bus) send(dst uint16, op uint8, requestData []byte, response interface{}) bool {
-	f := Frame{src: DevSAM, dst: dst, op: op, data: requestData}
-	act := &Action{requestFrame: f, ch: make(chan bool)}
+func nackError(req Frame, res Frame) *NackError {
+	err := &NackError{Device: res.src, Op: req.op}
+	if len(res.data) > 0 {
+		err.Code = NackCode(res.data[0])
+	}
+	if (req.op == ReadTableBlock || req.op == WriteTableBlock) && len(req.data) >= 3 {
+		var addr TableAddr
+		copy(addr[:], req.data[0:3])
+		err.Table = &addr
+	}
+	return err
+}
+
+// exchange sends a request to dst and returns the response to it.
+func (b *Bus) exchange(ctx context.Context, dst uint16, op uint8, requestData []byte, prio priority) (*Frame, error) {
+	if b.passive.Load() {
+		// Nothing is ever transmitted by a passive bus.  Table reads are
+		// answered from the tables seen on the bus, writes are refused and
+		// other reads can't be answered at all.
+		if prio == priorityWrite {
+			return nil, ErrReadOnly
+		}
+		if op != ReadTableBlock {
+			return nil, ErrPassive
+		}
+		res := b.snapshotFrame(dst, requestData)
+		if res == nil {
+			return nil, ErrNotSeen
+		}
+		return res, nil
+	}
+
+	deadline := time.Now().Add(requestDeadlines[prio])
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	f := Frame{src: b.addr, dst: dst, op: op, data: requestData}
+	act := &Action{
+		ctx:          ctx,
+		requestFrame: f,
+		priority:     prio,
+		deadline:     deadline,
+		ch:           make(chan error, 1),
+	}
 
-	// Send action to action handling goroutine
-	b.actionCh <- act
+	// Queue action for the action handling goroutine
+	if err := b.scheduler.push(act); err != nil {
+		log.Printf("gave up queueing action: %s: %s", f, err.Error())
+		return nil, err
+	}
 	// Wait for response
-	ok := <-act.ch
-
-	if ok && op == ReadTableBlock && act.responseFrame != nil && act.responseFrame.data != nil && len(act.responseFrame.data) > 6 {
-		raw, ok := response.(rawRequest)
-		if ok {
-			log.Printf(">>>> handling a RawRequest")
-			*raw.Data = append(*raw.Data, act.responseFrame.data[6:]...)
-			log.Printf("raw data length is: %d", len(*raw.Data))
-		} else {
-			r := bytes.NewReader(act.responseFrame.data[6:])
-			binary.Read(r, binary.BigEndian, response)
+	select {
+	case err := <-act.ch:
+		if err != nil {
+			return nil, err
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return act.responseFrame, nil
+}
+
+func (b *Bus) send(ctx context.Context, dst uint16, op uint8, requestData []byte, response interface{}, prio priority) error {
+	res, err := b.exchange(ctx, dst, op, requestData, prio)
+	if err != nil {
+		return err
+	}
+
+	if op == ReadTableBlock {
+		return decodeResponse(res, response)
+	}
+	return nil
+}
+
+// decodeResponse decodes the table carried by a read response into response.
+func decodeResponse(res *Frame, response interface{}) error {
+	var addr TableAddr
+	copy(addr[:], res.data)
+
+	raw, ok := response.(rawRequest)
+	start := 3 + tableHeaderLength
+	want := 1
+	if !ok {
+		want = binary.Size(response)
+		if t, isTable := response.(Table); isTable && LayoutOf(t).Headerless {
+			start = 3
 		}
-		// log.Printf("%+v", data)
 	}
+	if len(res.data) < start || len(res.data)-start < want {
+		return &DecodeError{Table: addr, Want: want, Got: max(len(res.data)-start, 0)}
+	}
+
+	if ok {
+		log.Printf(">>>> handling a RawRequest")
+		if raw.Header {
+			start = 3
+		}
+		*raw.Data = append(*raw.Data, res.data[start:]...)
+		log.Printf("raw data length is: %d", len(*raw.Data))
+		return nil
+	}
+
+	r := bytes.NewReader(res.data[start:])
+	return binary.Read(r, binary.BigEndian, response)
+}
+
+// snapshot remembers the table carried by a response or write seen on the
+// bus, keyed by the device that sent it.
+func (b *Bus) snapshot(frame Frame) {
+	if len(frame.data) < 6 {
+		return
+	}
+	var addr TableAddr
+	copy(addr[:], frame.data[0:3])
+
+	b.tablesMu.Lock()
+	defer b.tablesMu.Unlock()
 
-	return ok
+	tables, ok := b.tables[frame.src]
+	if !ok {
+		tables = make(map[TableAddr][]byte)
+		b.tables[frame.src] = tables
+	}
+	// Writes may only carry part of a table; don't let one replace a more
+	// complete copy.
+	if prev, ok := tables[addr]; ok && len(frame.data) < len(prev) {
+		return
+	}
+	tables[addr] = frame.Clone().data
+}
+
+// snapshotFrame returns the most recent frame seen carrying table from src, or
+// nil if there is none.
+func (b *Bus) snapshotFrame(src uint16, table []byte) *Frame {
+	var addr TableAddr
+	copy(addr[:], table)
+
+	b.tablesMu.Lock()
+	defer b.tablesMu.Unlock()
+
+	data, ok := b.tables[src][addr]
+	if !ok {
+		return nil
+	}
+	return &Frame{src: src, dst: b.addr, op: Ack06, data: data}
 }
 
 func (b *Bus) Write(dst uint16, table []byte, addr []byte, params interface{}) bool {
+	return b.WriteContext(context.Background(), dst, table, addr, params) == nil
+}
+
+func (b *Bus) WriteContext(ctx context.Context, dst uint16, table []byte, addr []byte, params interface{}) error {
+	if len(table) != 3 || len(addr) != 3 {
+		return invalidArgument("table and address must be 3 bytes")
+	}
+
 	buf := new(bytes.Buffer)
 	buf.Write(table[:])
 	buf.Write(addr[:])
-	binary.Write(buf, binary.BigEndian, params)
+	if err := binary.Write(buf, binary.BigEndian, params); err != nil {
+		return invalidArgument("encoding parameters: %s", err.Error())
+	}
+	if buf.Len() > maxFrameData {
+		return invalidArgument("table, address and parameters must be at most %d bytes, got %d", maxFrameData, buf.Len())
+	}
 
-	return b.send(dst, WriteTableBlock, buf.Bytes(), nil)
+	return b.send(ctx, dst, WriteTableBlock, buf.Bytes(), nil, priorityWrite)
 }
 
 func (b *Bus) WriteTable(dst uint16, table Table, flags uint8) bool {
+	return b.WriteTableContext(context.Background(), dst, table, flags) == nil
+}
+
+func (b *Bus) WriteTableContext(ctx context.Context, dst uint16, table Table, flags uint8) error {
 	addr := table.addr()
 	fl := []byte{0x00, 0x00, flags}
-	return b.Write(dst, addr[:], fl, table)
+	return b.WriteContext(ctx, dst, addr[:], fl, table)
 }
 
 func (b *Bus) Read(dst uint16, addr TableAddr, params interface{}) bool {
-	return b.send(dst, ReadTableBlock, addr[:], params)
+	return b.ReadContext(context.Background(), dst, addr, params) == nil
+}
+
+func (b *Bus) ReadContext(ctx context.Context, dst uint16, addr TableAddr, params interface{}) error {
+	return b.send(ctx, dst, ReadTableBlock, addr[:], params, priorityRead)
 }
 
 func (b *Bus) ReadTable(dst uint16, table Table) bool {
+	return b.ReadTableContext(context.Background(), dst, table) == nil
+}
+
+func (b *Bus) ReadTableContext(ctx context.Context, dst uint16, table Table) error {
+	return b.readTable(ctx, dst, table, priorityRead)
+}
+
+func (b *Bus) readTable(ctx context.Context, dst uint16, table Table, prio priority) error {
 	addr := table.addr()
-	return b.send(dst, ReadTableBlock, addr[:], table)
+	return b.send(ctx, dst, ReadTableBlock, addr[:], table, prio)
+}
+
+// record adds a byte run to the capture, if one is enabled.
+func (b *Bus) record(inbound bool, data []byte, rejected bool) {
+	if b.capture != nil && len(data) > 0 {
+		b.capture.record(time.Now(), inbound, data, rejected)
+	}
 }
 
 func (b *Bus) sendFrame(buf []byte) bool {
-	// Ensure we're not in the middle of reopening the serial port due to an error.
-	if b.port == nil {
+	if !b.writeFrame(buf) {
+		return false
+	}
+
+	if f, ok := DecodeFrame(buf); ok {
+		b.mu.Lock()
+		for _, monitor := range b.sentMonitors {
+			monitor(f)
+		}
+		b.mu.Unlock()
+	}
+	return true
+}
+
+func (b *Bus) writeFrame(buf []byte) bool {
+	// Holding the lock keeps the port from being reopened or closed in the
+	// middle of a frame.
+	b.portMu.Lock()
+	defer b.portMu.Unlock()
+
+	if b.port == nil || b.passive.Load() {
 		return false
 	}
 
 	log.Debugf("transmitting frame: %x", buf)
 	_, err := b.port.Write(buf)
 	if err != nil {
-		log.Errorf("error writing to serial: %s", err.Error())
+		log.Errorf("error writing to bus: %s", err.Error())
 		b.port.Close()
 		b.port = nil
 		return false
 	}
+	b.record(false, buf, false)
 	return true
 }
 
@@ -278,6 +802,21 @@ func (b *Bus) SnoopResponse(f func(Frame)) {
 	b.mu.Unlock()
 }
 
+// Monitor registers f to be called with every frame read from the bus.
+func (b *Bus) Monitor(f func(Frame)) {
+	b.mu.Lock()
+	b.monitors = append(b.monitors, f)
+	b.mu.Unlock()
+}
+
+// MonitorSent registers f to be called with every frame transmitted on the
+// bus, including retransmissions.
+func (b *Bus) MonitorSent(f func(Frame)) {
+	b.mu.Lock()
+	b.sentMonitors = append(b.sentMonitors, f)
+	b.mu.Unlock()
+}
+
 func filter(p framePredicate, fn frameHandler) frameHandler {
 	return func(f Frame) {
 		if p(f) {
@@ -0,0 +1,19 @@
+package infinity_test
+
+import (
+	"testing"
+
+	"github.com/acd/infinitive/infinity"
+	"github.com/acd/infinitive/infinity/bustest"
+)
+
+func newTestBus(t *testing.T, fb *bustest.Bus, opts ...infinity.BusOption) *infinity.Bus {
+	t.Helper()
+	opts = append(opts, infinity.WithPortOpener(fb.Open))
+	bus, err := infinity.NewBus("bustest", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bus.Close() })
+	return bus
+}
@@ -0,0 +1,191 @@
+// Package bustest provides an in-memory ABCD bus for testing code built on
+// the infinity package without an HVAC system attached.
+//
+// A Bus plays the part of a thermostat, answering reads and writes of its
+// tables, and can inject traffic from other devices or drop and corrupt the
+// frames it delivers:
+//
+//	fb := bustest.NewBus()
+//	fb.Thermostat.SetTable(&infinity.TStatZoneParams{HeatSetpoint: [8]uint8{68}})
+//	api, err := bustest.NewApi(ctx, fb)
+package bustest
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/acd/infinitive/infinity"
+)
+
+// Fault alters the next frame delivered by a Bus.
+type Fault int
+
+const (
+	// Deliver passes the frame through unchanged.
+	Deliver Fault = iota
+	// Drop discards the frame.
+	Drop
+	// Corrupt damages the frame's checksum.
+	Corrupt
+)
+
+var errClosed = errors.New("bustest: port closed")
+
+// Bus is an in-memory ABCD bus.
+type Bus struct {
+	Thermostat *Thermostat
+
+	mu     sync.Mutex
+	conn   *conn
+	faults []Fault
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		Thermostat: NewThermostat(),
+	}
+}
+
+// Open connects a new port to the bus, disconnecting any previous one.  It
+// satisfies infinity.PortOpener.
+func (b *Bus) Open() (infinity.Port, error) {
+	c := &conn{bus: b}
+	c.cond = sync.NewCond(&c.mu)
+
+	b.mu.Lock()
+	prev := b.conn
+	b.conn = c
+	b.mu.Unlock()
+
+	if prev != nil {
+		prev.Close()
+	}
+	return c, nil
+}
+
+// NewApi creates an infinity.Api connected to b.
+func NewApi(ctx context.Context, b *Bus, opts ...infinity.BusOption) (*infinity.Api, error) {
+	opts = append(opts, infinity.WithPortOpener(b.Open))
+	bus, err := infinity.NewBus("bustest", opts...)
+	if err != nil {
+		return nil, err
+	}
+	return infinity.NewApiWithBus(ctx, bus), nil
+}
+
+// InjectFaults queues faults to apply, in order, to the next frames the bus
+// delivers to the connected port.
+func (b *Bus) InjectFaults(faults ...Fault) {
+	b.mu.Lock()
+	b.faults = append(b.faults, faults...)
+	b.mu.Unlock()
+}
+
+// Inject delivers a frame from src to dst to the connected port.
+func (b *Bus) Inject(src uint16, dst uint16, op uint8, data []byte) {
+	b.deliver(infinity.NewFrame(src, dst, op, data).Encode())
+}
+
+// InjectRaw delivers raw bytes to the connected port, bypassing faults.
+func (b *Bus) InjectRaw(buf []byte) {
+	b.mu.Lock()
+	c := b.conn
+	b.mu.Unlock()
+
+	if c != nil {
+		c.push(buf)
+	}
+}
+
+// InjectExchange delivers a thermostat read of table from dst followed by
+// dst's response carrying payload.
+func (b *Bus) InjectExchange(dst uint16, table infinity.TableAddr, payload []byte) {
+	b.Inject(infinity.DevTSTAT, dst, infinity.ReadTableBlock, table[:])
+	b.Inject(dst, infinity.DevTSTAT, infinity.Ack06, append(table[:], payload...))
+}
+
+func (b *Bus) deliver(buf []byte) {
+	b.mu.Lock()
+	fault := Deliver
+	if len(b.faults) > 0 {
+		fault = b.faults[0]
+		b.faults = b.faults[1:]
+	}
+	b.mu.Unlock()
+
+	switch fault {
+	case Drop:
+		return
+	case Corrupt:
+		buf = append([]byte{}, buf...)
+		buf[len(buf)-1] ^= 0xff
+	}
+	b.InjectRaw(buf)
+}
+
+// transmit handles a frame written to the bus by the connected port.
+func (b *Bus) transmit(buf []byte) {
+	frame, ok := infinity.DecodeFrame(buf)
+	if !ok {
+		return
+	}
+
+	if frame.Dst() == infinity.DevTSTAT {
+		if response, ok := b.Thermostat.handle(frame); ok {
+			b.deliver(response.Encode())
+		}
+	}
+}
+
+// conn is the port end of the in-memory bus.
+type conn struct {
+	bus    *Bus
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    []byte
+	closed bool
+}
+
+func (c *conn) push(buf []byte) {
+	c.mu.Lock()
+	c.buf = append(c.buf, buf...)
+	c.mu.Unlock()
+	c.cond.Broadcast()
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.buf) == 0 && !c.closed {
+		c.cond.Wait()
+	}
+	if c.closed {
+		return 0, errClosed
+	}
+
+	n := copy(b, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+
+	if closed {
+		return 0, errClosed
+	}
+	c.bus.transmit(append([]byte{}, b...))
+	return len(b), nil
+}
+
+func (c *conn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	c.cond.Broadcast()
+	return nil
+}
@@ -0,0 +1,266 @@
+package bustest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+
+	"github.com/acd/infinitive/infinity"
+)
+
+// layouts describe the tables the thermostat accepts partial writes to.
+// Writes to other tables replace the whole table.
+var layouts = map[infinity.TableAddr]*infinity.Layout{}
+
+func init() {
+	for _, t := range []infinity.Table{
+		infinity.TStatCurrentParams{},
+		infinity.TStatZoneParams{},
+		infinity.TStatVacationParams{},
+		infinity.TStatSettings{},
+	} {
+		layouts[infinity.AddrOf(t)] = infinity.LayoutOf(t)
+	}
+}
+
+// Write records a table write received by the thermostat.
+type Write struct {
+	Src   uint16
+	Table infinity.TableAddr
+	Flags byte
+	Data  []byte
+}
+
+// Thermostat is a scripted thermostat answering table reads and writes and
+// variable requests.
+type Thermostat struct {
+	mu        sync.Mutex
+	tables    map[infinity.TableAddr][]byte
+	writes    []Write
+	refused   map[infinity.TableAddr]infinity.NackCode
+	variables map[string]*variable
+}
+
+type variable struct {
+	value  []byte
+	forced []byte
+}
+
+// NewThermostat returns a thermostat holding zeroed 003B02, 003B03, 003B04
+// and 003B06 tables and a blank identification table.
+// Requests for any other table are rejected with NackUnknownTable.
+func NewThermostat() *Thermostat {
+	t := &Thermostat{
+		tables:    make(map[infinity.TableAddr][]byte),
+		refused:   make(map[infinity.TableAddr]infinity.NackCode),
+		variables: make(map[string]*variable),
+	}
+	t.SetTable(&infinity.TStatCurrentParams{})
+	t.SetTable(&infinity.TStatZoneParams{})
+	t.SetTable(&infinity.TStatVacationParams{})
+	t.SetTable(&infinity.TStatSettings{})
+	t.SetTable(&infinity.DeviceInfoParams{})
+	return t
+}
+
+// SetTable sets the contents of the table described by table.
+func (t *Thermostat) SetTable(table infinity.Table) {
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, table)
+	t.SetRaw(infinity.AddrOf(table), b.Bytes())
+}
+
+// SetRaw sets the contents of the table at addr.
+func (t *Thermostat) SetRaw(addr infinity.TableAddr, data []byte) {
+	t.mu.Lock()
+	t.tables[addr] = append([]byte{}, data...)
+	t.mu.Unlock()
+}
+
+// Table decodes the current contents of a table into table, which must be a
+// pointer.  It returns false if the thermostat has no such table.
+func (t *Thermostat) Table(table infinity.Table) bool {
+	data, ok := t.Raw(infinity.AddrOf(table))
+	if !ok {
+		return false
+	}
+	return binary.Read(bytes.NewReader(data), binary.BigEndian, table) == nil
+}
+
+// Raw returns the current contents of the table at addr.
+func (t *Thermostat) Raw(addr infinity.TableAddr) ([]byte, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	data, ok := t.tables[addr]
+	return append([]byte{}, data...), ok
+}
+
+// Writes returns the table writes received so far.
+func (t *Thermostat) Writes() []Write {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]Write{}, t.writes...)
+}
+
+// RefuseWrites makes the thermostat reject writes to the table at addr with
+// code.
+func (t *Thermostat) RefuseWrites(addr infinity.TableAddr, code infinity.NackCode) {
+	t.mu.Lock()
+	t.refused[addr] = code
+	t.mu.Unlock()
+}
+
+// SetVariable sets the value of the variable at addr, which RDVAR requests
+// for exactly addr return unless it is forced.
+func (t *Thermostat) SetVariable(addr []byte, value []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	v, ok := t.variables[string(addr)]
+	if !ok {
+		v = &variable{}
+		t.variables[string(addr)] = v
+	}
+	v.value = append([]byte{}, value...)
+}
+
+// Variable returns the value of the variable at addr and the value it is
+// forced to, if any.
+func (t *Thermostat) Variable(addr []byte) (value []byte, forced []byte, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	v, ok := t.variables[string(addr)]
+	if !ok {
+		return nil, nil, false
+	}
+	return append([]byte{}, v.value...), append([]byte(nil), v.forced...), true
+}
+
+// handleVariable answers RDVAR, FORCE and AUTO requests.  t.mu must be held.
+func (t *Thermostat) handleVariable(req infinity.Frame) infinity.Frame {
+	data := req.Data()
+	for addr, v := range t.variables {
+		if !bytes.HasPrefix(data, []byte(addr)) {
+			continue
+		}
+		rest := data[len(addr):]
+
+		switch {
+		case req.Op() == infinity.ReadVariable && len(rest) == 0:
+			value := v.value
+			if v.forced != nil {
+				value = v.forced
+			}
+			return infinity.NewFrame(infinity.DevTSTAT, req.Src(), infinity.Ack06, append([]byte{}, value...))
+		case req.Op() == infinity.WriteVariable && len(rest) > 0:
+			v.forced = append([]byte{}, rest...)
+			return infinity.NewFrame(infinity.DevTSTAT, req.Src(), infinity.Ack06, []byte{0x00})
+		case req.Op() == infinity.AutoVariable && len(rest) == 0:
+			v.forced = nil
+			return infinity.NewFrame(infinity.DevTSTAT, req.Src(), infinity.Ack06, []byte{0x00})
+		}
+	}
+	return nack(req, infinity.NackUnknownTable)
+}
+
+func nack(req infinity.Frame, code infinity.NackCode) infinity.Frame {
+	return infinity.NewFrame(infinity.DevTSTAT, req.Src(), infinity.Nack, []byte{uint8(code)})
+}
+
+func (t *Thermostat) handle(req infinity.Frame) (infinity.Frame, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch req.Op() {
+	case infinity.ReadVariable, infinity.WriteVariable, infinity.AutoVariable:
+		return t.handleVariable(req), true
+	case infinity.ReadTableBlock, infinity.WriteTableBlock:
+	default:
+		return nack(req, infinity.NackUnsupportedOp), true
+	}
+
+	data := req.Data()
+	if len(data) < 3 {
+		return nack(req, infinity.NackBadLength), true
+	}
+	var addr infinity.TableAddr
+	copy(addr[:], data[0:3])
+
+	table, ok := t.tables[addr]
+	if !ok {
+		return nack(req, infinity.NackUnknownTable), true
+	}
+
+	if req.Op() == infinity.ReadTableBlock {
+		response := append(addr[:], 0x00, 0x00, 0x00)
+		response = append(response, table...)
+		return infinity.NewFrame(infinity.DevTSTAT, req.Src(), infinity.Ack06, response), true
+	}
+
+	if len(data) < 6 {
+		return nack(req, infinity.NackBadLength), true
+	}
+	if code, ok := t.refused[addr]; ok {
+		return nack(req, code), true
+	}
+	w := Write{Src: req.Src(), Table: addr, Flags: data[5], Data: append([]byte{}, data[6:]...)}
+	t.writes = append(t.writes, w)
+	t.tables[addr] = applyWrite(addr, table, w)
+	return infinity.NewFrame(infinity.DevTSTAT, req.Src(), infinity.Ack06, []byte{0x00}), true
+}
+
+// applyWrite returns table with w applied, changing only the fields its
+// flags allow.
+func applyWrite(addr infinity.TableAddr, table []byte, w Write) []byte {
+	layout, ok := layouts[addr]
+	if !ok || !layout.Writable() {
+		return append([]byte{}, w.Data...)
+	}
+
+	table = append([]byte{}, table...)
+	for _, f := range layout.Fields {
+		if w.Flags&f.Flag == 0 {
+			continue
+		}
+		offset, length := f.Span()
+		if offset+length <= len(w.Data) && offset+length <= len(table) {
+			copy(table[offset:offset+length], w.Data[offset:])
+		}
+	}
+	return table
+}
+
+// Default addresses used when injecting equipment traffic.
+const (
+	AirHandlerAddr = uint16(0x4001)
+	HeatPumpAddr   = uint16(0x5001)
+)
+
+// AirHandlerStatus injects thermostat polls of the air handler reporting the
+// given blower speed, air flow and electric heat state.
+func (b *Bus) AirHandlerStatus(blowerRPM uint16, airFlowCFM uint16, elecHeat bool) {
+	blower := make([]byte, 5)
+	binary.BigEndian.PutUint16(blower[1:3], blowerRPM)
+	b.InjectExchange(AirHandlerAddr, infinity.TableAddr{0x00, 0x03, 0x06}, blower)
+
+	status := make([]byte, 14)
+	if elecHeat {
+		status[0] = 0x03
+	}
+	binary.BigEndian.PutUint16(status[4:6], airFlowCFM)
+	b.InjectExchange(AirHandlerAddr, infinity.TableAddr{0x00, 0x03, 0x16}, status)
+}
+
+// HeatPumpStatus injects thermostat polls of the heat pump reporting the given
+// temperatures, in degrees, and compressor stage.
+func (b *Bus) HeatPumpStatus(outsideTemp float32, coilTemp float32, stage uint8) {
+	temps := make([]byte, 4)
+	binary.BigEndian.PutUint16(temps[0:2], uint16(outsideTemp*16))
+	binary.BigEndian.PutUint16(temps[2:4], uint16(coilTemp*16))
+	b.InjectExchange(HeatPumpAddr, infinity.TableAddr{0x00, 0x3e, 0x01}, temps)
+
+	b.InjectExchange(HeatPumpAddr, infinity.TableAddr{0x00, 0x3e, 0x02}, []byte{stage << 1})
+}
@@ -0,0 +1,396 @@
+package infinity
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Bus traffic is captured as pcapng with a user-defined link type; every
+// packet is one byte run read from or written to the bus.
+const (
+	pcapngSectionHeader   = uint32(0x0a0d0d0a)
+	pcapngInterface       = uint32(0x00000001)
+	pcapngEnhancedPacket  = uint32(0x00000006)
+	pcapngByteOrderMagic  = uint32(0x1a2b3c4d)
+	pcapngLinkTypeUser0   = uint16(147)
+	pcapngOptEnd          = uint16(0)
+	pcapngOptComment      = uint16(1)
+	pcapngOptIfName       = uint16(2)
+	pcapngOptIfTsresol    = uint16(9)
+	pcapngOptEpbFlags     = uint16(2)
+	pcapngFlagInbound     = uint32(0x01)
+	pcapngFlagOutbound    = uint32(0x02)
+	pcapngFlagDirection   = uint32(0x03)
+	pcapngFlagCRCError    = uint32(0x01000000)
+	pcapngMaxBlockLength  = 1 << 20
+	pcapngDefaultTsresol  = uint8(6)
+	captureRejectedRemark = "rejected"
+)
+
+// Capture records bus traffic to a pcapng file.  Decoded frames and the byte
+// runs the reader had to discard are both recorded, the latter flagged with a
+// CRC error.
+type Capture struct {
+	w  io.Writer
+	mu sync.Mutex
+}
+
+// NewCapture writes the pcapng headers to w and returns a Capture recording
+// to it.
+func NewCapture(w io.Writer) (*Capture, error) {
+	c := &Capture{w: w}
+
+	var shb bytes.Buffer
+	binary.Write(&shb, binary.LittleEndian, pcapngByteOrderMagic)
+	binary.Write(&shb, binary.LittleEndian, uint16(1)) // major version
+	binary.Write(&shb, binary.LittleEndian, uint16(0)) // minor version
+	binary.Write(&shb, binary.LittleEndian, int64(-1)) // unknown section length
+	if err := c.writeBlock(pcapngSectionHeader, shb.Bytes()); err != nil {
+		return nil, err
+	}
+
+	var idb bytes.Buffer
+	binary.Write(&idb, binary.LittleEndian, pcapngLinkTypeUser0)
+	binary.Write(&idb, binary.LittleEndian, uint16(0)) // reserved
+	binary.Write(&idb, binary.LittleEndian, uint32(0)) // no snap length
+	writeOption(&idb, pcapngOptIfName, []byte("abcd"))
+	writeOption(&idb, pcapngOptEnd, nil)
+	if err := c.writeBlock(pcapngInterface, idb.Bytes()); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func writeOption(b *bytes.Buffer, code uint16, value []byte) {
+	binary.Write(b, binary.LittleEndian, code)
+	binary.Write(b, binary.LittleEndian, uint16(len(value)))
+	b.Write(value)
+	b.Write(make([]byte, pad4(len(value))))
+}
+
+func pad4(n int) int {
+	return (4 - n%4) % 4
+}
+
+func (c *Capture) writeBlock(blockType uint32, body []byte) error {
+	length := uint32(12 + len(body))
+
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, blockType)
+	binary.Write(&b, binary.LittleEndian, length)
+	b.Write(body)
+	binary.Write(&b, binary.LittleEndian, length)
+
+	_, err := c.w.Write(b.Bytes())
+	return err
+}
+
+// record appends a byte run to the capture.  Inbound runs were read from the
+// bus, outbound runs were transmitted by us.
+func (c *Capture) record(ts time.Time, inbound bool, data []byte, rejected bool) {
+	flags := pcapngFlagOutbound
+	if inbound {
+		flags = pcapngFlagInbound
+	}
+	if rejected {
+		flags |= pcapngFlagCRCError
+	}
+
+	usec := uint64(ts.UnixMicro())
+
+	var epb bytes.Buffer
+	binary.Write(&epb, binary.LittleEndian, uint32(0)) // interface id
+	binary.Write(&epb, binary.LittleEndian, uint32(usec>>32))
+	binary.Write(&epb, binary.LittleEndian, uint32(usec))
+	binary.Write(&epb, binary.LittleEndian, uint32(len(data)))
+	binary.Write(&epb, binary.LittleEndian, uint32(len(data)))
+	epb.Write(data)
+	epb.Write(make([]byte, pad4(len(data))))
+
+	fl := make([]byte, 4)
+	binary.LittleEndian.PutUint32(fl, flags)
+	writeOption(&epb, pcapngOptEpbFlags, fl)
+	if rejected {
+		writeOption(&epb, pcapngOptComment, []byte(captureRejectedRemark))
+	}
+	writeOption(&epb, pcapngOptEnd, nil)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.writeBlock(pcapngEnhancedPacket, epb.Bytes()); err != nil {
+		log.Errorf("error writing capture: %s", err.Error())
+	}
+}
+
+// captureRecord is a byte run read back from a capture.
+type captureRecord struct {
+	ts       time.Time
+	inbound  bool
+	rejected bool
+	data     []byte
+}
+
+// captureReader reads the packets of a pcapng capture.
+type captureReader struct {
+	r       io.Reader
+	order   binary.ByteOrder
+	tsunits []uint64 // timestamp units per second, by interface
+}
+
+func newCaptureReader(r io.Reader) *captureReader {
+	return &captureReader{r: r, order: binary.LittleEndian}
+}
+
+func (cr *captureReader) next() (*captureRecord, error) {
+	for {
+		blockType, body, err := cr.readBlock()
+		if err != nil {
+			return nil, err
+		}
+
+		switch blockType {
+		case pcapngSectionHeader:
+			cr.tsunits = nil
+		case pcapngInterface:
+			cr.tsunits = append(cr.tsunits, cr.parseTsresol(body))
+		case pcapngEnhancedPacket:
+			if rec, ok := cr.parsePacket(body); ok {
+				return rec, nil
+			}
+		}
+	}
+}
+
+func (cr *captureReader) readBlock() (uint32, []byte, error) {
+	hdr := make([]byte, 8)
+	if _, err := io.ReadFull(cr.r, hdr); err != nil {
+		return 0, nil, err
+	}
+
+	blockType := cr.order.Uint32(hdr[0:4])
+	if blockType == pcapngSectionHeader {
+		// The byte order magic following the length determines how the rest of
+		// the section, including this block's length, is encoded.
+		magic := make([]byte, 4)
+		if _, err := io.ReadFull(cr.r, magic); err != nil {
+			return 0, nil, err
+		}
+		if binary.LittleEndian.Uint32(magic) == pcapngByteOrderMagic {
+			cr.order = binary.LittleEndian
+		} else if binary.BigEndian.Uint32(magic) == pcapngByteOrderMagic {
+			cr.order = binary.BigEndian
+		} else {
+			return 0, nil, errors.New("invalid pcapng byte order magic")
+		}
+		hdr = append(hdr, magic...)
+	}
+
+	length := cr.order.Uint32(hdr[4:8])
+	if length < uint32(len(hdr))+4 || length > pcapngMaxBlockLength || length%4 != 0 {
+		return 0, nil, fmt.Errorf("invalid pcapng block length %d", length)
+	}
+
+	rest := make([]byte, int(length)-len(hdr))
+	if _, err := io.ReadFull(cr.r, rest); err != nil {
+		return 0, nil, err
+	}
+	body := append(hdr[8:], rest[:len(rest)-4]...)
+	return blockType, body, nil
+}
+
+// options parses the options starting at buf, calling fn for each.
+func (cr *captureReader) options(buf []byte, fn func(code uint16, value []byte)) {
+	for len(buf) >= 4 {
+		code := cr.order.Uint16(buf[0:2])
+		l := int(cr.order.Uint16(buf[2:4]))
+		if code == pcapngOptEnd || 4+l > len(buf) {
+			return
+		}
+		fn(code, buf[4:4+l])
+		buf = buf[min(len(buf), 4+l+pad4(l)):]
+	}
+}
+
+// parseTsresol returns the number of timestamp units per second of an
+// interface.
+func (cr *captureReader) parseTsresol(body []byte) uint64 {
+	tsresol := pcapngDefaultTsresol
+	if len(body) > 8 {
+		cr.options(body[8:], func(code uint16, value []byte) {
+			if code == pcapngOptIfTsresol && len(value) == 1 {
+				tsresol = value[0]
+			}
+		})
+	}
+
+	exp := tsresol & 0x7f
+	switch {
+	case tsresol&0x80 != 0 && exp < 64:
+		return 1 << exp
+	case tsresol&0x80 == 0 && exp < 20:
+		units := uint64(1)
+		for i := uint8(0); i < exp; i++ {
+			units *= 10
+		}
+		return units
+	}
+	// Finer than anything can measure; treat it as the default
+	return 1_000_000
+}
+
+// timestamp converts a timestamp counted in units per second.  Timestamps
+// are split into seconds first, as float64 can't hold a nanosecond count.
+func timestamp(ts uint64, units uint64) time.Time {
+	sec, frac := ts/units, ts%units
+	var nsec uint64
+	if units <= 1e9 {
+		nsec = frac * 1e9 / units
+	} else {
+		nsec = uint64(float64(frac) / float64(units) * 1e9)
+	}
+	return time.Unix(int64(sec), int64(nsec))
+}
+
+func (cr *captureReader) parsePacket(body []byte) (*captureRecord, bool) {
+	if len(body) < 20 {
+		return nil, false
+	}
+
+	iface := int(cr.order.Uint32(body[0:4]))
+	ts := uint64(cr.order.Uint32(body[4:8]))<<32 | uint64(cr.order.Uint32(body[8:12]))
+	capLen := int(cr.order.Uint32(body[12:16]))
+	if 20+capLen > len(body) {
+		return nil, false
+	}
+
+	units := uint64(1_000_000)
+	if iface < len(cr.tsunits) {
+		units = cr.tsunits[iface]
+	}
+
+	rec := &captureRecord{
+		ts:      timestamp(ts, units),
+		inbound: true,
+		data:    append([]byte{}, body[20:20+capLen]...),
+	}
+
+	optStart := 20 + capLen + pad4(capLen)
+	if optStart < len(body) {
+		cr.options(body[optStart:], func(code uint16, value []byte) {
+			if code == pcapngOptEpbFlags && len(value) == 4 {
+				flags := cr.order.Uint32(value)
+				rec.inbound = flags&pcapngFlagDirection != pcapngFlagOutbound
+				rec.rejected = flags&pcapngFlagCRCError != 0
+			}
+		})
+	}
+
+	return rec, true
+}
+
+// replayPort plays back the inbound traffic of a capture with its original
+// timing.  Writes are discarded.  Once the capture is exhausted reads block
+// until the port is closed.
+type replayPort struct {
+	f       *os.File
+	r       *captureReader
+	pending []byte
+	start   time.Time
+	first   time.Time
+	closed  chan struct{}
+	once    sync.Once
+}
+
+var errReplayClosed = errors.New("replay closed")
+
+func openReplay(path string) (*replayPort, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &replayPort{
+		f:      f,
+		r:      newCaptureReader(f),
+		closed: make(chan struct{}),
+	}, nil
+}
+
+func (p *replayPort) Read(b []byte) (int, error) {
+	for len(p.pending) == 0 {
+		rec, err := p.r.next()
+		if err != nil {
+			if err != io.EOF {
+				log.Errorf("error reading capture: %s", err.Error())
+			}
+			log.Info("replay finished")
+			<-p.closed
+			return 0, errReplayClosed
+		}
+		if !rec.inbound {
+			continue
+		}
+
+		if p.start.IsZero() {
+			p.start = time.Now()
+			p.first = rec.ts
+		}
+
+		wait := time.Until(p.start.Add(rec.ts.Sub(p.first)))
+		if wait > 0 {
+			t := time.NewTimer(wait)
+			select {
+			case <-t.C:
+			case <-p.closed:
+				t.Stop()
+				return 0, errReplayClosed
+			}
+		}
+		p.pending = rec.data
+	}
+
+	n := copy(b, p.pending)
+	p.pending = p.pending[n:]
+	return n, nil
+}
+
+func (p *replayPort) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (p *replayPort) Close() error {
+	p.once.Do(func() {
+		close(p.closed)
+	})
+	return p.f.Close()
+}
+
+// WithCapture records all bus traffic to c.
+func WithCapture(c *Capture) BusOption {
+	return func(b *Bus) {
+		b.capture = c
+	}
+}
+
+// WithReplay feeds the bus from a capture file instead of a serial port.  The
+// bus is passive during a replay, so state is reconstructed from the recorded
+// traffic.
+func WithReplay(path string) BusOption {
+	return func(b *Bus) {
+		b.device = path
+		b.passive.Store(true)
+		// The capture ends eventually, that's not a reason to reopen it
+		b.link.silence = 0
+		b.open = func() (Port, error) {
+			return openReplay(path)
+		}
+	}
+}
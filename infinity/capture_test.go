@@ -0,0 +1,150 @@
+package infinity
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCaptureRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	c, err := NewCapture(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 1, 5, 17, 2, 11, 112345000, time.UTC)
+	want := []*captureRecord{
+		{ts: start, inbound: true, data: []byte{0x20, 0x01, 0x92, 0x01, 0x03, 0x00, 0x00, 0x0b}},
+		{ts: start.Add(time.Millisecond), inbound: false, data: []byte{0x92}},
+		{ts: start.Add(2 * time.Millisecond), inbound: true, rejected: true, data: []byte{0xff, 0x00, 0x13}},
+		{ts: start.Add(3 * time.Millisecond), inbound: true, data: []byte{0x01, 0x02, 0x03, 0x04, 0x05}},
+	}
+	for _, rec := range want {
+		c.record(rec.ts, rec.inbound, rec.data, rec.rejected)
+	}
+
+	r := newCaptureReader(&buf)
+	for i, w := range want {
+		rec, err := r.next()
+		if err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		if !rec.ts.Equal(w.ts) {
+			t.Errorf("record %d: time %v, want %v", i, rec.ts, w.ts)
+		}
+		rec.ts = w.ts
+		if !reflect.DeepEqual(rec, w) {
+			t.Errorf("record %d: got %+v, want %+v", i, rec, w)
+		}
+	}
+	if _, err := r.next(); err != io.EOF {
+		t.Errorf("after the last record: got %v, want EOF", err)
+	}
+}
+
+// TestCaptureReadBigEndian reads a capture written by another tool, in big
+// endian order with nanosecond timestamps.
+func TestCaptureReadBigEndian(t *testing.T) {
+	var buf bytes.Buffer
+	block := func(blockType uint32, body []byte) {
+		length := uint32(12 + len(body))
+		binary.Write(&buf, binary.BigEndian, blockType)
+		binary.Write(&buf, binary.BigEndian, length)
+		buf.Write(body)
+		binary.Write(&buf, binary.BigEndian, length)
+	}
+	option := func(b *bytes.Buffer, code uint16, value []byte) {
+		binary.Write(b, binary.BigEndian, code)
+		binary.Write(b, binary.BigEndian, uint16(len(value)))
+		b.Write(value)
+		b.Write(make([]byte, pad4(len(value))))
+	}
+
+	var shb bytes.Buffer
+	binary.Write(&shb, binary.BigEndian, pcapngByteOrderMagic)
+	binary.Write(&shb, binary.BigEndian, []uint16{1, 0})
+	binary.Write(&shb, binary.BigEndian, int64(-1))
+	block(pcapngSectionHeader, shb.Bytes())
+
+	var idb bytes.Buffer
+	binary.Write(&idb, binary.BigEndian, pcapngLinkTypeUser0)
+	binary.Write(&idb, binary.BigEndian, []uint16{0, 0, 0})
+	option(&idb, pcapngOptIfTsresol, []byte{9})
+	option(&idb, pcapngOptEnd, nil)
+	block(pcapngInterface, idb.Bytes())
+
+	ts := time.Date(2024, 1, 5, 17, 2, 11, 123456789, time.UTC)
+	ns := uint64(ts.UnixNano())
+	var epb bytes.Buffer
+	binary.Write(&epb, binary.BigEndian, []uint32{0, uint32(ns >> 32), uint32(ns), 2, 2})
+	epb.Write([]byte{0xab, 0xcd, 0, 0})
+	flags := make([]byte, 4)
+	binary.BigEndian.PutUint32(flags, pcapngFlagOutbound)
+	option(&epb, pcapngOptEpbFlags, flags)
+	option(&epb, pcapngOptEnd, nil)
+	block(pcapngEnhancedPacket, epb.Bytes())
+
+	rec, err := newCaptureReader(&buf).next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rec.ts.Equal(ts) || rec.inbound || rec.rejected || !bytes.Equal(rec.data, []byte{0xab, 0xcd}) {
+		t.Errorf("got %+v", rec)
+	}
+}
+
+func TestCaptureRejectsBadBlocks(t *testing.T) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, []uint32{pcapngSectionHeader, 28, 0x12345678})
+	if _, err := newCaptureReader(&buf).next(); err == nil {
+		t.Error("bad byte order magic accepted")
+	}
+
+	buf.Reset()
+	binary.Write(&buf, binary.LittleEndian, []uint32{pcapngEnhancedPacket, 10})
+	if _, err := newCaptureReader(&buf).next(); err == nil {
+		t.Error("bad block length accepted")
+	}
+}
+
+func TestReplayOnlyPlaysInbound(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bus.pcapng")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCapture(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	c.record(start, true, []byte{0x01, 0x02}, false)
+	c.record(start.Add(time.Millisecond), false, []byte{0x03}, false)
+	c.record(start.Add(2*time.Millisecond), true, []byte{0x04}, true)
+	f.Close()
+
+	p, err := openReplay(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	got := []byte{}
+	b := make([]byte, 1)
+	for len(got) < 3 {
+		n, err := p.Read(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, b[:n]...)
+	}
+	if want := []byte{0x01, 0x02, 0x04}; !bytes.Equal(got, want) {
+		t.Errorf("replayed %x, want %x", got, want)
+	}
+}
@@ -0,0 +1,96 @@
+package infinity
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// FieldChange is a change to a field of a table, seen on the bus.
+type FieldChange struct {
+	Device    string `json:"device"`
+	Table     string `json:"table"`
+	TableName string `json:"tableName"`
+	Field     string `json:"field"`
+	// Element of a field with several, such as the zone, counting from 0
+	Index *int      `json:"index,omitempty"`
+	Old   any       `json:"old"`
+	New   any       `json:"new"`
+	Time  time.Time `json:"time"`
+}
+
+func (c FieldChange) String() string {
+	field := c.Field
+	if c.Index != nil {
+		field = fmt.Sprintf("%s[%d]", c.Field, *c.Index)
+	}
+	return fmt.Sprintf("%s %s %s changed %v -> %v", c.Device, c.TableName, field, c.Old, c.New)
+}
+
+type tableKey struct {
+	device string
+	table  string
+}
+
+// tableState keeps the most recent decoded copy of every table seen on the
+// bus, to work out what changed in the next one.
+type tableState struct {
+	mu     sync.Mutex
+	tables map[tableKey]map[string]any
+}
+
+func newTableState() *tableState {
+	return &tableState{tables: make(map[tableKey]map[string]any)}
+}
+
+// update records a decoded table, returning the fields that changed since it
+// was last seen.  Nothing has changed the first time a table is seen.
+// Fields missing from t, as in partial writes, keep their values.
+func (s *tableState) update(t *DecodedTable, now time.Time) []FieldChange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := tableKey{t.Device, t.Table}
+	prev, seen := s.tables[key]
+	if !seen {
+		prev = make(map[string]any)
+		s.tables[key] = prev
+	}
+
+	changes := []FieldChange{}
+	for _, f := range t.Fields {
+		old, ok := prev[f.Name]
+		prev[f.Name] = f.Value
+		if !ok {
+			continue
+		}
+
+		change := FieldChange{
+			Device:    t.Device,
+			Table:     t.Table,
+			TableName: t.Name,
+			Field:     f.Name,
+			Time:      now,
+		}
+		olds, isSlice := old.([]any)
+		news, _ := f.Value.([]any)
+		if !isSlice || len(olds) != len(news) {
+			if !reflect.DeepEqual(old, f.Value) {
+				change.Old, change.New = old, f.Value
+				changes = append(changes, change)
+			}
+			continue
+		}
+		for i := range news {
+			if olds[i] != news[i] {
+				i := i
+				c := change
+				c.Index = &i
+				c.Old, c.New = olds[i], news[i]
+				changes = append(changes, c)
+			}
+		}
+	}
+	return changes
+}
@@ -0,0 +1,99 @@
+package infinity
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func decodedTable(device string, fields ...FieldValue) *DecodedTable {
+	return &DecodedTable{Device: device, Table: "003b03", Name: "TStatZoneParams", Fields: fields}
+}
+
+func TestTableStateUpdate(t *testing.T) {
+	s := newTableState()
+	now := time.Date(2024, 1, 5, 17, 2, 11, 0, time.UTC)
+	index := func(i int) *int { return &i }
+
+	steps := []struct {
+		name  string
+		table *DecodedTable
+		want  []FieldChange
+	}{
+		{
+			name: "first sighting",
+			table: decodedTable("2001",
+				FieldValue{"ZoneHold", int64(0)},
+				FieldValue{"HeatSetpoint", []any{int64(68), int64(66)}}),
+			want: []FieldChange{},
+		},
+		{
+			name: "unchanged",
+			table: decodedTable("2001",
+				FieldValue{"ZoneHold", int64(0)},
+				FieldValue{"HeatSetpoint", []any{int64(68), int64(66)}}),
+			want: []FieldChange{},
+		},
+		{
+			name: "scalar and element changes",
+			table: decodedTable("2001",
+				FieldValue{"ZoneHold", int64(2)},
+				FieldValue{"HeatSetpoint", []any{int64(70), int64(66)}}),
+			want: []FieldChange{
+				{Field: "ZoneHold", Old: int64(0), New: int64(2)},
+				{Field: "HeatSetpoint", Index: index(0), Old: int64(68), New: int64(70)},
+			},
+		},
+		{
+			name:  "partial write",
+			table: decodedTable("2001", FieldValue{"HeatSetpoint", []any{int64(70), int64(64)}}),
+			want: []FieldChange{
+				{Field: "HeatSetpoint", Index: index(1), Old: int64(66), New: int64(64)},
+			},
+		},
+		{
+			name:  "fields missing from a partial write keep their values",
+			table: decodedTable("2001", FieldValue{"ZoneHold", int64(0)}),
+			want: []FieldChange{
+				{Field: "ZoneHold", Old: int64(2), New: int64(0)},
+			},
+		},
+		{
+			name:  "new field",
+			table: decodedTable("2001", FieldValue{"FanAutoCfg", int64(1)}),
+			want:  []FieldChange{},
+		},
+		{
+			name:  "another device",
+			table: decodedTable("2002", FieldValue{"ZoneHold", int64(1)}),
+			want:  []FieldChange{},
+		},
+		{
+			name:  "element count changed",
+			table: decodedTable("2001", FieldValue{"HeatSetpoint", []any{int64(70)}}),
+			want: []FieldChange{
+				{Field: "HeatSetpoint", Old: []any{int64(70), int64(64)}, New: []any{int64(70)}},
+			},
+		},
+	}
+
+	for _, step := range steps {
+		for i := range step.want {
+			step.want[i].Device = step.table.Device
+			step.want[i].Table = "003b03"
+			step.want[i].TableName = "TStatZoneParams"
+			step.want[i].Time = now
+		}
+		if got := s.update(step.table, now); !reflect.DeepEqual(got, step.want) {
+			t.Errorf("%s: got %+v, want %+v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestFieldChangeString(t *testing.T) {
+	i := 1
+	c := FieldChange{Device: "2001", TableName: "TStatZoneParams", Field: "HeatSetpoint", Index: &i, Old: int64(66), New: int64(64)}
+	if got, want := c.String(), "2001 TStatZoneParams HeatSetpoint[1] changed 66 -> 64"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -1,5 +1,7 @@
 package infinity
 
+import "strings"
+
 func RawModeToString(mode uint8) string {
 	switch mode {
 	case 0:
@@ -64,3 +66,26 @@ func StringFanModeToRaw(mode string) (uint8, bool) {
 		return 0, false
 	}
 }
+
+// Zone names are 12 bytes in TStatZoneParams.
+const zoneNameLength = 12
+
+// StringZoneNameToRaw converts a zone name to the fixed length, NUL padded
+// form string fields are stored in, upper casing it.  Only letters, digits
+// and spaces are accepted, to stay clear of characters the thermostat may
+// not be able to show.
+func StringZoneNameToRaw(name string) ([zoneNameLength]byte, error) {
+	raw := [zoneNameLength]byte{}
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if len(name) == 0 || len(name) > zoneNameLength {
+		return raw, invalidArgument("zone name must be 1 to %d characters", zoneNameLength)
+	}
+	for _, c := range name {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == ' ') {
+			return raw, invalidArgument("zone name may only contain letters, digits and spaces")
+		}
+	}
+
+	copy(raw[:], name)
+	return raw, nil
+}
@@ -0,0 +1,45 @@
+package infinity
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStringZoneNameToRaw(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"MAIN", "MAIN\x00\x00\x00\x00\x00\x00\x00\x00", true},
+		{"living room", "LIVING ROOM\x00", true},
+		{"  Den  ", "DEN\x00\x00\x00\x00\x00\x00\x00\x00\x00", true},
+		{"UPSTAIRS 123", "UPSTAIRS 123", true},
+		{"", "", false},
+		{"   ", "", false},
+		{"THIRTEEN CHRS", "", false},
+		{"KID'S ROOM", "", false},
+		{"BÄD", "", false},
+		{"ZONE\x00", "", false},
+	}
+
+	for _, tt := range tests {
+		raw, err := StringZoneNameToRaw(tt.name)
+		if !tt.ok {
+			if !errors.Is(err, ErrInvalidArgument) {
+				t.Errorf("StringZoneNameToRaw(%q): got error %v, want ErrInvalidArgument", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("StringZoneNameToRaw(%q): %v", tt.name, err)
+			continue
+		}
+		if string(raw[:]) != tt.want {
+			t.Errorf("StringZoneNameToRaw(%q) = %q, want %q", tt.name, raw[:], tt.want)
+		}
+		if got := trimString(raw[:]); got != string(raw[:len(got)]) {
+			t.Errorf("StringZoneNameToRaw(%q) doesn't read back, got %q", tt.name, got)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package infinity
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TableDefinitions is the format of a table definitions file, in YAML or
+// JSON:
+//
+//	tables:
+//	  - device: heat pump
+//	    table: 003e01
+//	    name: HeatPumpTemperatures
+//	    headerless: true
+//	    fields:
+//	      - {name: OutsideTemp, type: int16, scale: 16}
+//	      - {name: CoilTemp, offset: 2, type: int16, scale: 16}
+//	  - device: heat pump
+//	    table: 003e02
+//	    name: HeatPumpStage
+//	    headerless: true
+//	    fields:
+//	      - {name: Stage, type: uint8, enum: {0: off, 2: low, 4: high}}
+type TableDefinitions struct {
+	Tables []TableDefinition `yaml:"tables" json:"tables"`
+}
+
+// TableDefinition describes the fields of a table.
+type TableDefinition struct {
+	// Device address as 4 hex digits, a device class such as "heat pump", or
+	// empty for a table found on every device
+	Device string `yaml:"device" json:"device"`
+	// Table address as 6 hex digits
+	Table string `yaml:"table" json:"table"`
+	Name  string `yaml:"name" json:"name"`
+	// Whether fields follow the table address directly, rather than the 3
+	// byte header most tables have
+	Headerless bool              `yaml:"headerless" json:"headerless"`
+	Fields     []FieldDefinition `yaml:"fields" json:"fields"`
+}
+
+// FieldDefinition describes a field of a table.  Fields without an offset
+// follow the previous one.
+type FieldDefinition struct {
+	Name   string           `yaml:"name" json:"name"`
+	Offset *int             `yaml:"offset" json:"offset"`
+	Type   FieldType        `yaml:"type" json:"type"`
+	Length int              `yaml:"length" json:"length"`
+	Count  int              `yaml:"count" json:"count"`
+	Scale  float64          `yaml:"scale" json:"scale"`
+	Enum   map[int64]string `yaml:"enum" json:"enum"`
+}
+
+// DecodedTable is a table decoded into named fields.
+type DecodedTable struct {
+	Device string       `json:"device"`
+	Table  string       `json:"table"`
+	Name   string       `json:"name"`
+	Fields []FieldValue `json:"fields"`
+	Raw    string       `json:"raw"`
+}
+
+var deviceClasses = map[string]bool{
+	"thermostat":        true,
+	"air handler":       true,
+	"heat pump":         true,
+	"zone controller":   true,
+	"network interface": true,
+	"sam":               true,
+}
+
+// layout converts the definition to a layout, checking it makes sense.
+func (d TableDefinition) layout() (*Layout, error) {
+	addr, err := hex.DecodeString(d.Table)
+	if err != nil || len(addr) != 3 {
+		return nil, fmt.Errorf("table %q: address must be 6 hex digits", d.Table)
+	}
+	if d.Device != "" && !deviceClasses[strings.ToLower(d.Device)] {
+		if _, err := strconv.ParseUint(d.Device, 16, 16); err != nil || len(d.Device) != 4 {
+			return nil, fmt.Errorf("table %s: device %q is neither 4 hex digits nor a device class", d.Table, d.Device)
+		}
+	}
+
+	l := &Layout{Name: d.Name, Headerless: d.Headerless}
+	copy(l.Addr[:], addr)
+	if l.Name == "" {
+		l.Name = d.Table
+	}
+
+	offset := 0
+	for _, fd := range d.Fields {
+		f := Field{
+			Name:   fd.Name,
+			Offset: offset,
+			Type:   fd.Type,
+			Length: fd.Length,
+			Count:  fd.Count,
+			Scale:  fd.Scale,
+			Enum:   fd.Enum,
+		}
+		if fd.Offset != nil {
+			f.Offset = *fd.Offset
+		}
+
+		switch {
+		case f.Name == "":
+			return nil, fmt.Errorf("table %s: field at offset %d has no name", d.Table, f.Offset)
+		case f.Offset < 0 || f.Count < 0 || f.Scale < 0:
+			return nil, fmt.Errorf("table %s: field %s: offset, count and scale can't be negative", d.Table, f.Name)
+		}
+		switch f.Type {
+		case FieldUint8, FieldInt8, FieldUint16, FieldInt16:
+			if f.Length != 0 {
+				return nil, fmt.Errorf("table %s: field %s: only strings and bytes have a length", d.Table, f.Name)
+			}
+		case FieldString, FieldBytes:
+			if f.Length <= 0 {
+				return nil, fmt.Errorf("table %s: field %s: %s needs a length", d.Table, f.Name, f.Type)
+			}
+		default:
+			return nil, fmt.Errorf("table %s: field %s: unknown type %q", d.Table, f.Name, f.Type)
+		}
+
+		l.Fields = append(l.Fields, f)
+		_, length := f.Span()
+		offset = f.Offset + length
+		l.Size = max(l.Size, offset)
+	}
+	return l, nil
+}
+
+type definition struct {
+	device string
+	layout *Layout
+}
+
+// matches reports how closely the definition matches device: 0 if it
+// doesn't, 1 for any device, 2 for its class and 3 for its address.
+func (d definition) matches(device uint16) int {
+	switch d.device {
+	case "":
+		return 1
+	case DeviceClass(device):
+		return 2
+	case fmt.Sprintf("%04x", device):
+		return 3
+	}
+	return 0
+}
+
+// tableRegistry holds the layouts used to decode tables seen on the bus.
+type tableRegistry struct {
+	mu          sync.RWMutex
+	definitions map[TableAddr][]definition
+}
+
+// newTableRegistry returns a registry knowing the tables infinitive has
+// structs for.
+func newTableRegistry() *tableRegistry {
+	r := &tableRegistry{definitions: make(map[TableAddr][]definition)}
+	for _, t := range []Table{
+		TStatCurrentParams{},
+		TStatZoneParams{},
+		TStatVacationParams{},
+		TStatSettings{},
+	} {
+		r.add("thermostat", LayoutOf(t))
+	}
+	r.add("", LayoutOf(DeviceInfoParams{}))
+	for _, t := range []Table{
+		AirHandlerBlower{},
+		AirHandlerAirflow{},
+	} {
+		r.add("air handler", LayoutOf(t))
+	}
+	for _, t := range []Table{
+		HeatPumpTemperatures{},
+		HeatPumpStage{},
+	} {
+		r.add("heat pump", LayoutOf(t))
+	}
+	return r
+}
+
+func (r *tableRegistry) add(device string, l *Layout) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.definitions[l.Addr] = append(r.definitions[l.Addr], definition{device: strings.ToLower(device), layout: l})
+}
+
+// load adds the definitions in path, or in every .yaml, .yml and .json file
+// in it if it's a directory.  Definitions loaded later take precedence over
+// earlier ones for the same device.
+func (r *tableRegistry) load(path string) (int, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	if !info.IsDir() {
+		return r.loadFile(path)
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return 0, err
+	}
+	loaded := 0
+	for _, e := range entries {
+		switch filepath.Ext(e.Name()) {
+		case ".yaml", ".yml", ".json":
+			n, err := r.loadFile(filepath.Join(path, e.Name()))
+			if err != nil {
+				return loaded, err
+			}
+			loaded += n
+		}
+	}
+	return loaded, nil
+}
+
+func (r *tableRegistry) loadFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	// JSON is YAML too, but YAML won't read the string keys JSON objects
+	// have into enums
+	unmarshal := yaml.Unmarshal
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		unmarshal = json.Unmarshal
+	}
+	var defs TableDefinitions
+	if err := unmarshal(data, &defs); err != nil {
+		return 0, fmt.Errorf("reading table definitions %s: %w", path, err)
+	}
+
+	layouts := make([]*Layout, len(defs.Tables))
+	for i, d := range defs.Tables {
+		if layouts[i], err = d.layout(); err != nil {
+			return 0, fmt.Errorf("reading table definitions %s: %w", path, err)
+		}
+	}
+	for i, d := range defs.Tables {
+		r.add(d.Device, layouts[i])
+	}
+	return len(layouts), nil
+}
+
+// lookup returns the layout of table on device, preferring the most specific
+// definition.
+func (r *tableRegistry) lookup(device uint16, table TableAddr) (*Layout, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var best *Layout
+	bestMatch := 0
+	for _, d := range r.definitions[table] {
+		if m := d.matches(device); m > 0 && m >= bestMatch {
+			best, bestMatch = d.layout, m
+		}
+	}
+	return best, best != nil
+}
+
+// decode decodes table on device from body, the data following the table
+// address in a frame.
+func (r *tableRegistry) decode(device uint16, table TableAddr, body []byte) (*DecodedTable, error) {
+	l, ok := r.lookup(device, table)
+	if !ok {
+		return nil, ErrNoDefinition
+	}
+	data := l.fieldData(body)
+	return &DecodedTable{
+		Device: fmt.Sprintf("%04x", device),
+		Table:  hex.EncodeToString(table[:]),
+		Name:   l.Name,
+		Fields: l.Decode(data),
+		Raw:    hex.EncodeToString(data),
+	}, nil
+}
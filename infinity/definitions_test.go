@@ -0,0 +1,135 @@
+package infinity
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeDefinitions(t *testing.T, dir string, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDefinitionsEnums(t *testing.T) {
+	dir := t.TempDir()
+	writeDefinitions(t, dir, "stage.yaml", `
+tables:
+  - device: heat pump
+    table: 003e02
+    name: YAMLStage
+    headerless: true
+    fields:
+      - {name: Stage, type: uint8, enum: {0: off, 2: low, 4: high}}
+`)
+	writeDefinitions(t, dir, "mode.json", `{
+  "tables": [{
+    "device": "thermostat",
+    "table": "003b02",
+    "name": "JSONCurrent",
+    "fields": [{"name": "Mode", "offset": 19, "type": "uint8", "enum": {"0": "heat", "1": "cool"}}]
+  }]
+}`)
+	writeDefinitions(t, dir, "notes.txt", "not a definitions file")
+
+	r := newTableRegistry()
+	n, err := r.load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("loaded %d definitions, want 2", n)
+	}
+
+	decoded, err := r.decode(0x5001, TableAddr{0x00, 0x3e, 0x02}, []byte{0x04})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Name != "YAMLStage" || !reflect.DeepEqual(decoded.Fields, []FieldValue{{"Stage", "high"}}) {
+		t.Errorf("YAML enum decoded as %+v", decoded)
+	}
+
+	body := make([]byte, 3+20)
+	body[3+19] = 1
+	decoded, err = r.decode(DevTSTAT, TableAddr{0x00, 0x3b, 0x02}, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Name != "JSONCurrent" || !reflect.DeepEqual(decoded.Fields, []FieldValue{{"Mode", "cool"}}) {
+		t.Errorf("JSON enum decoded as %+v", decoded)
+	}
+}
+
+func TestDefinitionPrecedence(t *testing.T) {
+	path := writeDefinitions(t, t.TempDir(), "tables.yml", `
+tables:
+  - table: 00ff01
+    name: AnyDevice
+  - device: air handler
+    table: 00ff01
+    name: AirHandlers
+  - device: "4001"
+    table: 00ff01
+    name: FirstAirHandler
+  - device: air handler
+    table: 00ff01
+    name: AirHandlersLater
+`)
+	r := newTableRegistry()
+	if _, err := r.load(path); err != nil {
+		t.Fatal(err)
+	}
+
+	for device, want := range map[uint16]string{
+		0x4001: "FirstAirHandler",
+		0x4002: "AirHandlersLater",
+		0x5001: "AnyDevice",
+	} {
+		if l, ok := r.lookup(device, TableAddr{0x00, 0xff, 0x01}); !ok || l.Name != want {
+			t.Errorf("device %04x: got %v, want %s", device, l, want)
+		}
+	}
+	if _, err := r.decode(0x4001, TableAddr{0x00, 0xff, 0x02}, nil); err != ErrNoDefinition {
+		t.Errorf("unknown table: got %v, want ErrNoDefinition", err)
+	}
+}
+
+func TestLoadDefinitionsErrors(t *testing.T) {
+	tests := []struct {
+		fields string
+		table  string
+		device string
+		want   string
+	}{
+		{table: "3e01", want: "address must be 6 hex digits"},
+		{table: "003e01", device: "furnace", want: "neither 4 hex digits nor a device class"},
+		{table: "003e01", fields: "[{type: uint8}]", want: "has no name"},
+		{table: "003e01", fields: "[{name: A, type: string}]", want: "needs a length"},
+		{table: "003e01", fields: "[{name: A, type: uint8, length: 2}]", want: "only strings and bytes have a length"},
+		{table: "003e01", fields: "[{name: A, type: float}]", want: "unknown type"},
+		{table: "003e01", fields: "[{name: A, offset: -1, type: uint8}]", want: "can't be negative"},
+	}
+
+	dir := t.TempDir()
+	for _, tt := range tests {
+		content := "tables:\n  - table: " + tt.table + "\n"
+		if tt.device != "" {
+			content += "    device: " + tt.device + "\n"
+		}
+		if tt.fields != "" {
+			content += "    fields: " + tt.fields + "\n"
+		}
+		path := writeDefinitions(t, dir, "bad.yaml", content)
+
+		r := newTableRegistry()
+		if _, err := r.load(path); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: got error %v, want %q", content, err, tt.want)
+		}
+	}
+}
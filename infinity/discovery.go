@@ -0,0 +1,201 @@
+package infinity
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// How often the identifier looks for devices to identify
+	identifyInterval = 5 * time.Second
+	// Failed identification attempts are retried after identifyRetry, up to
+	// identifyAttempts times
+	identifyRetry    = 30 * time.Second
+	identifyAttempts = 3
+)
+
+// DeviceInfoParams is the identification table every device exposes.
+type DeviceInfoParams struct {
+	Module   [24]byte `infinity:"string"`
+	Firmware [16]byte `infinity:"string"`
+	Model    [20]byte `infinity:"string"`
+	Serial   [36]byte `infinity:"string"`
+}
+
+func (params DeviceInfoParams) addr() TableAddr {
+	return TableAddr{0x00, 0x01, 0x04}
+}
+
+type DeviceInfo struct {
+	Module   string `json:"module"`
+	Firmware string `json:"firmware"`
+	Model    string `json:"model"`
+	Serial   string `json:"serial"`
+}
+
+func (params *DeviceInfoParams) ToAPI() *DeviceInfo {
+	return &DeviceInfo{
+		Module:   trimString(params.Module[:]),
+		Firmware: trimString(params.Firmware[:]),
+		Model:    trimString(params.Model[:]),
+		Serial:   trimString(params.Serial[:]),
+	}
+}
+
+// trimString converts a fixed length, NUL padded string field to a string.
+func trimString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(bytes.TrimSpace(b))
+}
+
+// DeviceClass infers the kind of device at a bus address.
+func DeviceClass(addr uint16) string {
+	switch addr >> 8 {
+	case 0x20:
+		return "thermostat"
+	case 0x40, 0x41, 0x42:
+		return "air handler"
+	case 0x50, 0x51:
+		return "heat pump"
+	case 0x60:
+		return "zone controller"
+	case 0x80:
+		return "network interface"
+	case 0x92:
+		return "sam"
+	default:
+		return "unknown"
+	}
+}
+
+// Device describes an address seen on the bus.
+type Device struct {
+	Address   string      `json:"address"`
+	Class     string      `json:"class"`
+	FirstSeen time.Time   `json:"firstSeen"`
+	LastSeen  time.Time   `json:"lastSeen"`
+	Ops       []string    `json:"ops"`
+	Tables    []string    `json:"tables"`
+	Info      *DeviceInfo `json:"info,omitempty"`
+}
+
+type deviceRecord struct {
+	firstSeen time.Time
+	lastSeen  time.Time
+	// Set once the device has sent a frame, as opposed to only being
+	// addressed by others
+	active    bool
+	ops       map[uint8]struct{}
+	tables    map[TableAddr]struct{}
+	info      *DeviceInfo
+	attempts  int
+	nextProbe time.Time
+}
+
+// inventory tracks every address seen on the bus.
+type inventory struct {
+	mu      sync.Mutex
+	devices map[uint16]*deviceRecord
+}
+
+func newInventory() *inventory {
+	return &inventory{
+		devices: make(map[uint16]*deviceRecord),
+	}
+}
+
+func (inv *inventory) device(addr uint16, now time.Time) *deviceRecord {
+	d, ok := inv.devices[addr]
+	if !ok {
+		d = &deviceRecord{
+			firstSeen: now,
+			ops:       make(map[uint8]struct{}),
+			tables:    make(map[TableAddr]struct{}),
+		}
+		inv.devices[addr] = d
+	}
+	d.lastSeen = now
+	return d
+}
+
+func (inv *inventory) observe(f Frame, now time.Time) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	src := inv.device(f.src, now)
+	src.active = true
+	dst := inv.device(f.dst, now)
+
+	for _, d := range []*deviceRecord{src, dst} {
+		d.ops[f.op] = struct{}{}
+		if len(f.data) >= 3 && (f.op == ReadTableBlock || f.op == WriteTableBlock || f.op == Ack06) {
+			var addr TableAddr
+			copy(addr[:], f.data[0:3])
+			d.tables[addr] = struct{}{}
+		}
+	}
+}
+
+// unidentified returns the active devices other than self due for an
+// identification attempt.
+func (inv *inventory) unidentified(self uint16, now time.Time) []uint16 {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	var addrs []uint16
+	for addr, d := range inv.devices {
+		if addr != self && d.active && d.info == nil && d.attempts < identifyAttempts && !now.Before(d.nextProbe) {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
+
+func (inv *inventory) identified(addr uint16, info *DeviceInfo) {
+	inv.mu.Lock()
+	inv.devices[addr].info = info
+	inv.mu.Unlock()
+}
+
+func (inv *inventory) failed(addr uint16, now time.Time) {
+	inv.mu.Lock()
+	d := inv.devices[addr]
+	d.attempts++
+	d.nextProbe = now.Add(identifyRetry)
+	inv.mu.Unlock()
+}
+
+func (inv *inventory) list() []Device {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	devices := []Device{}
+	for addr, d := range inv.devices {
+		dev := Device{
+			Address:   fmt.Sprintf("%04x", addr),
+			Class:     DeviceClass(addr),
+			FirstSeen: d.firstSeen,
+			LastSeen:  d.lastSeen,
+			Ops:       []string{},
+			Tables:    []string{},
+			Info:      d.info,
+		}
+		for op := range d.ops {
+			dev.Ops = append(dev.Ops, opToString(op))
+		}
+		for t := range d.tables {
+			dev.Tables = append(dev.Tables, fmt.Sprintf("%x", t[:]))
+		}
+		sort.Strings(dev.Ops)
+		sort.Strings(dev.Tables)
+		devices = append(devices, dev)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Address < devices[j].Address })
+	return devices
+}
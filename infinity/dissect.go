@@ -0,0 +1,210 @@
+package infinity
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Dissection is an annotated breakdown of a frame.
+type Dissection struct {
+	Dst Endpoint `json:"dst"`
+	Src Endpoint `json:"src"`
+	// Data length given by the frame, and whether it matches the data
+	Length      int  `json:"length"`
+	LengthValid bool `json:"lengthValid"`
+	// Header bytes 5 and 6, whose meaning is unknown
+	Unknown string `json:"unknown"`
+	Op      string `json:"op"`
+	Data    string `json:"data"`
+	// Checksum carried by the frame, and the one expected if it's wrong
+	Checksum         string `json:"checksum"`
+	ChecksumValid    bool   `json:"checksumValid"`
+	ExpectedChecksum string `json:"expectedChecksum,omitempty"`
+
+	Table *DissectedTable `json:"table,omitempty"`
+	Nack  *DissectedNack  `json:"nack,omitempty"`
+	Alarm *DissectedAlarm `json:"alarm,omitempty"`
+}
+
+// Endpoint is the sender or recipient of a frame.
+type Endpoint struct {
+	Address string `json:"address"`
+	Class   string `json:"class"`
+}
+
+// DissectedTable describes the table a frame reads or carries.
+type DissectedTable struct {
+	Address string `json:"address"`
+	// Empty for tables nobody has described
+	Name string `json:"name,omitempty"`
+	// The 3 bytes following the table address, if any, unless the table is
+	// known to have no header
+	Header string       `json:"header,omitempty"`
+	Fields []FieldValue `json:"fields,omitempty"`
+}
+
+// DissectedNack describes why a device rejected a request.
+type DissectedNack struct {
+	Code   uint8  `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// DissectedAlarm describes an alarm frame.  Like Alarm, it is decoded from an
+// unconfirmed layout.
+type DissectedAlarm struct {
+	Code        uint8  `json:"code"`
+	Description string `json:"description"`
+	Active      bool   `json:"active"`
+	Raw         string `json:"raw"`
+}
+
+func endpoint(addr uint16) Endpoint {
+	return Endpoint{Address: fmt.Sprintf("%04x", addr), Class: DeviceClass(addr)}
+}
+
+// dissect breaks down a frame given with its checksum.  Frames with a bad
+// checksum or length are dissected anyway, as far as possible.
+func (r *tableRegistry) dissect(buf []byte) (*Dissection, error) {
+	if len(buf) < 10 {
+		return nil, invalidArgument("a frame is at least 10 bytes, got %d", len(buf))
+	}
+
+	l := len(buf) - 2
+	f := Frame{
+		dst:     binary.BigEndian.Uint16(buf[0:2]),
+		src:     binary.BigEndian.Uint16(buf[2:4]),
+		dataLen: buf[4],
+		op:      buf[7],
+		data:    buf[8:l],
+	}
+	d := r.dissectFrame(f)
+	d.Length = int(buf[4])
+	d.LengthValid = d.Length == len(f.data)
+	d.Unknown = hex.EncodeToString(buf[5:7])
+	d.Checksum = hex.EncodeToString(buf[l:])
+	expected := checksum(buf[:l])
+	d.ChecksumValid = bytes.Equal(expected, buf[l:])
+	if !d.ChecksumValid {
+		d.ExpectedChecksum = hex.EncodeToString(expected)
+	}
+	return d, nil
+}
+
+// dissectFrame breaks down a decoded frame, whose checksum was already
+// checked.
+func (r *tableRegistry) dissectFrame(f Frame) *Dissection {
+	d := &Dissection{
+		Dst:           endpoint(f.dst),
+		Src:           endpoint(f.src),
+		Length:        len(f.data),
+		LengthValid:   true,
+		Op:            opToString(f.op),
+		Data:          hex.EncodeToString(f.data),
+		ChecksumValid: true,
+	}
+
+	switch f.op {
+	case ReadTableBlock, WriteTableBlock, Ack06:
+		// Reads name the table on the device they're sent to, writes and
+		// responses carry a table of the sender.  A response to a write
+		// carries no table.
+		owner := f.src
+		if f.op == ReadTableBlock {
+			owner = f.dst
+		}
+		if len(f.data) >= 3 {
+			d.Table = r.dissectTable(owner, f.data)
+		}
+	case Nack:
+		if len(f.data) > 0 {
+			code := NackCode(f.data[0])
+			d.Nack = &DissectedNack{Code: uint8(code), Reason: code.String()}
+		}
+	case AlarmPacket:
+		if a, ok := decodeAlarm(f, time.Time{}); ok {
+			d.Alarm = &DissectedAlarm{Code: a.Code, Description: a.Description, Active: a.Active, Raw: a.Raw}
+		}
+	}
+	return d
+}
+
+func (r *tableRegistry) dissectTable(device uint16, data []byte) *DissectedTable {
+	var addr TableAddr
+	copy(addr[:], data[0:3])
+	t := &DissectedTable{Address: hex.EncodeToString(addr[:])}
+
+	l, ok := r.lookup(device, addr)
+	if !ok {
+		if len(data) >= 6 {
+			t.Header = hex.EncodeToString(data[3:6])
+		}
+		return t
+	}
+
+	t.Name = l.Name
+	if !l.Headerless && len(data) >= 6 {
+		t.Header = hex.EncodeToString(data[3:6])
+	}
+	if l.Headerless || len(data) >= 6 {
+		t.Fields = l.Decode(l.fieldData(data[3:]))
+	}
+	return t
+}
+
+// Summary describes the dissection in a line.
+func (d *Dissection) Summary() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s -> %s %s: %s", d.Src.Class, d.Src.Address, d.Dst.Class, d.Dst.Address, d.Op)
+	if !d.ChecksumValid {
+		fmt.Fprintf(&b, " (bad checksum %s, expected %s)", d.Checksum, d.ExpectedChecksum)
+	}
+	if !d.LengthValid {
+		fmt.Fprintf(&b, " (length %d, but %d bytes of data)", d.Length, len(d.Data)/2)
+	}
+
+	switch {
+	case d.Table != nil:
+		fmt.Fprintf(&b, " table %s", d.Table.Address)
+		if d.Table.Name != "" {
+			fmt.Fprintf(&b, " (%s)", d.Table.Name)
+		}
+		if len(d.Table.Fields) > 0 {
+			fmt.Fprintf(&b, ": %s", formatFields(d.Table.Fields))
+		}
+	case d.Nack != nil:
+		fmt.Fprintf(&b, " %s (code %02x)", d.Nack.Reason, d.Nack.Code)
+	case d.Alarm != nil:
+		state := "cleared"
+		if d.Alarm.Active {
+			state = "active"
+		}
+		fmt.Fprintf(&b, " %s (code %d, %s)", d.Alarm.Description, d.Alarm.Code, state)
+	}
+	return b.String()
+}
+
+// WithDissection logs frames along with their dissection: the devices
+// involved, and the tables and fields they carry.
+func WithDissection() BusOption {
+	return func(b *Bus) {
+		b.dissect = true
+	}
+}
+
+// describe formats a frame for the log.
+func (b *Bus) describe(f Frame) string {
+	if !b.dissect {
+		return f.String()
+	}
+	return fmt.Sprintf("%s [%s]", f, b.definitions.dissectFrame(f).Summary())
+}
+
+// Dissect breaks down a frame, given with its checksum, into its parts and
+// the fields of any table it carries.
+func (b *Bus) Dissect(buf []byte) (*Dissection, error) {
+	return b.definitions.dissect(buf)
+}
@@ -0,0 +1,122 @@
+package infinity
+
+import (
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDissectHeaderlessTable(t *testing.T) {
+	r := newTableRegistry()
+	data, _ := hex.DecodeString("003e0102d80264")
+	d := r.dissectFrame(NewFrame(0x5001, DevTSTAT, Ack06, data))
+
+	if d.Src.Class != "heat pump" || d.Table == nil {
+		t.Fatalf("got %+v", d)
+	}
+	want := &DissectedTable{
+		Address: "003e01",
+		Name:    "HeatPumpTemperatures",
+		Fields: []FieldValue{
+			{Name: "OutsideTemp", Value: 45.5},
+			{Name: "CoilTemp", Value: 38.25},
+		},
+	}
+	if !reflect.DeepEqual(d.Table, want) {
+		t.Errorf("got table %+v, want %+v", d.Table, want)
+	}
+}
+
+func TestDissectTableWithHeader(t *testing.T) {
+	r := newTableRegistry()
+	data, _ := hex.DecodeString("003b0400000001")
+	d := r.dissectFrame(NewFrame(DevTSTAT, DevSAM, Ack06, data))
+
+	if d.Table == nil || d.Table.Name != "TStatVacationParams" || d.Table.Header != "000000" {
+		t.Fatalf("got table %+v", d.Table)
+	}
+	if len(d.Table.Fields) != 1 || d.Table.Fields[0] != (FieldValue{Name: "Active", Value: int64(1)}) {
+		t.Errorf("got fields %+v, want only Active=1", d.Table.Fields)
+	}
+}
+
+func TestDissectSnoopedTablesMatchLayouts(t *testing.T) {
+	r := newTableRegistry()
+	tests := []struct {
+		src   uint16
+		data  string
+		table Table
+		want  Table
+	}{
+		{0x5001, "003e01ffd00140", &HeatPumpTemperatures{}, &HeatPumpTemperatures{OutsideTemp: 0xffd0, CoilTemp: 0x0140}},
+		{0x5001, "003e0204", &HeatPumpStage{}, &HeatPumpStage{Stage: 4}},
+		{0x4001, "0003060102bc", &AirHandlerBlower{}, &AirHandlerBlower{Unknown: 1, BlowerRPM: 700}},
+		{0x4001, "00031603000000044c", &AirHandlerAirflow{}, &AirHandlerAirflow{State: 3, AirFlowCFM: 1100}},
+	}
+	for _, tt := range tests {
+		data, _ := hex.DecodeString(tt.data)
+		f := NewFrame(tt.src, DevTSTAT, Ack06, data)
+		if !snoopTable(&f, tt.table) {
+			t.Errorf("%s: not snooped", tt.data)
+			continue
+		}
+		if !reflect.DeepEqual(tt.table, tt.want) {
+			t.Errorf("%s: snooped %+v, want %+v", tt.data, tt.table, tt.want)
+		}
+
+		// The dissection decodes the same bytes as the snoop
+		d := r.dissectFrame(f)
+		l := LayoutOf(tt.want)
+		if d.Table == nil || d.Table.Name != l.Name || len(d.Table.Fields) != len(l.Fields) {
+			t.Errorf("%s: dissected as %+v", tt.data, d.Table)
+		}
+	}
+
+	f := NewFrame(0x5001, DevTSTAT, Ack06, []byte{0x00, 0x3e, 0x01, 0x02})
+	if snoopTable(&f, &HeatPumpTemperatures{}) {
+		t.Error("short table snooped")
+	}
+	if snoopTable(&f, &HeatPumpStage{}) {
+		t.Error("table snooped from a response carrying another")
+	}
+}
+
+func TestDissectBadChecksum(t *testing.T) {
+	r := newTableRegistry()
+	buf := NewFrame(DevSAM, DevTSTAT, ReadTableBlock, []byte{0x00, 0x3b, 0x02}).Encode()
+	good := hex.EncodeToString(buf[len(buf)-2:])
+	buf[len(buf)-1] ^= 0xff
+
+	d, err := r.dissect(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.ChecksumValid || d.ExpectedChecksum != good || d.Checksum != hex.EncodeToString(buf[len(buf)-2:]) {
+		t.Errorf("checksum %s valid=%v expected %s, want expected %s", d.Checksum, d.ChecksumValid, d.ExpectedChecksum, good)
+	}
+	if !d.LengthValid || d.Op != "READ" || d.Table == nil || d.Table.Name != "TStatCurrentParams" {
+		t.Errorf("frame with a bad checksum not dissected: %+v", d)
+	}
+	if !strings.Contains(d.Summary(), "bad checksum") {
+		t.Errorf("summary %q doesn't report the bad checksum", d.Summary())
+	}
+}
+
+func TestDissectBadLength(t *testing.T) {
+	r := newTableRegistry()
+	buf := NewFrame(DevSAM, DevTSTAT, ReadTableBlock, []byte{0x00, 0x3b, 0x02}).Encode()
+	buf[4] = 9
+	d, err := r.dissect(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.LengthValid || d.Length != 9 {
+		t.Errorf("length %d valid=%v, want 9 invalid", d.Length, d.LengthValid)
+	}
+
+	if _, err := r.dissect(buf[:9]); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("dissecting 9 bytes: got %v, want ErrInvalidArgument", err)
+	}
+}
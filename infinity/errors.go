@@ -0,0 +1,99 @@
+package infinity
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrTimeout is reported when a request got no response before its
+	// deadline, including time spent waiting for a busy bus.
+	ErrTimeout = errors.New("timed out waiting for response")
+	// ErrPortDown is reported when a request could not be transmitted because
+	// the bus interface is closed.
+	ErrPortDown = errors.New("bus interface is down")
+	// ErrReadOnly is reported for writes attempted on a passive bus.
+	ErrReadOnly = errors.New("read-only: infinitive is running in passive mode")
+	// ErrPassive is reported by a passive bus for requests other than table
+	// reads that don't change anything, which it has no way to answer.
+	ErrPassive = errors.New("passive mode: only table reads can be answered from bus traffic")
+	// ErrNotSeen is reported by a passive bus for reads of tables that
+	// haven't been seen on the bus yet.
+	ErrNotSeen = errors.New("table not seen on the bus yet")
+	// ErrClosed is reported for requests made after the bus was closed.
+	ErrClosed = errors.New("bus is closed")
+	// ErrAddressConflict is reported when another device is using our bus
+	// address.
+	ErrAddressConflict = errors.New("bus address already in use")
+	// ErrInvalidArgument wraps errors caused by bad arguments from the caller.
+	ErrInvalidArgument = errors.New("invalid argument")
+	// ErrUnconfirmed is reported for writes to fields whose layout or write
+	// flag hasn't been confirmed, which could change the wrong setting.
+	ErrUnconfirmed = errors.New("unconfirmed table layout")
+	// ErrNoDefinition is reported when asked to decode a table nobody has
+	// described.
+	ErrNoDefinition = errors.New("no definition for table")
+)
+
+// NackCode is the reason a device gives for rejecting a request.  The
+// reasons given for the codes below are unconfirmed guesses, and the list is
+// incomplete; other codes are reported by number.
+type NackCode uint8
+
+const (
+	NackUnsupportedOp NackCode = 0x01
+	NackUnknownTable  NackCode = 0x03
+	NackWriteRefused  NackCode = 0x04
+	NackBadLength     NackCode = 0x05
+	NackOutOfRange    NackCode = 0x06
+	NackBusy          NackCode = 0x0a
+)
+
+var nackReasons = map[NackCode]string{
+	NackUnsupportedOp: "operation not supported",
+	NackUnknownTable:  "unknown table",
+	NackWriteRefused:  "write refused",
+	NackBadLength:     "invalid length",
+	NackOutOfRange:    "value out of range",
+	NackBusy:          "device busy",
+}
+
+func (c NackCode) String() string {
+	if reason, ok := nackReasons[c]; ok {
+		return reason
+	}
+	return fmt.Sprintf("unknown reason %02x", uint8(c))
+}
+
+// NackError is reported when a device rejects a request.
+type NackError struct {
+	Device uint16
+	Op     uint8
+	// Table the request was for, if any
+	Table *TableAddr
+	Code  NackCode
+}
+
+func (e *NackError) Error() string {
+	request := opToString(e.Op)
+	if e.Table != nil {
+		request = fmt.Sprintf("%s of table %x", request, e.Table[:])
+	}
+	return fmt.Sprintf("device %04x rejected %s: %s (code %02x)", e.Device, request, e.Code, uint8(e.Code))
+}
+
+// DecodeError is reported when a response is too short for the table it
+// carries.
+type DecodeError struct {
+	Table TableAddr
+	Want  int
+	Got   int
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("response for table %x too short: got %d bytes, want %d", e.Table[:], e.Got, e.Want)
+}
+
+func invalidArgument(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidArgument, fmt.Sprintf(format, args...))
+}
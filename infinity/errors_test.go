@@ -0,0 +1,50 @@
+package infinity_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/acd/infinitive/infinity"
+	"github.com/acd/infinitive/infinity/bustest"
+)
+
+func TestNackIsNotRetried(t *testing.T) {
+	fb := bustest.NewBus()
+	addr := infinity.AddrOf(infinity.TStatZoneParams{})
+	fb.Thermostat.RefuseWrites(addr, infinity.NackWriteRefused)
+	api := newTestApi(t, fb)
+
+	writes := make(chan infinity.Frame, 10)
+	api.Bus.MonitorSent(func(f infinity.Frame) {
+		if f.Op() == infinity.WriteTableBlock {
+			writes <- f
+		}
+	})
+
+	params := infinity.TStatZoneParams{}
+	err := api.UpdateThermostatContext(context.Background(), params, infinity.LayoutOf(params).Flags("HeatSetpoint"))
+	var nack *infinity.NackError
+	if !errors.As(err, &nack) {
+		t.Fatalf("got error %v, want a NackError", err)
+	}
+	if nack.Device != infinity.DevTSTAT || nack.Code != infinity.NackWriteRefused || nack.Table == nil || *nack.Table != addr {
+		t.Fatalf("got %+v", nack)
+	}
+	if n := len(writes); n != 1 {
+		t.Fatalf("write sent %d times, want once", n)
+	}
+}
+
+func TestNackErrorMessage(t *testing.T) {
+	addr := infinity.AddrOf(infinity.TStatZoneParams{})
+	err := &infinity.NackError{Device: infinity.DevTSTAT, Op: infinity.WriteTableBlock, Table: &addr, Code: infinity.NackWriteRefused}
+	if got, want := err.Error(), "device 2001 rejected WRITE of table 003b03: write refused (code 04)"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	err = &infinity.NackError{Device: 0x4001, Op: infinity.ReadVariable, Code: 0x7f}
+	if got, want := err.Error(), "device 4001 rejected RDVAR: unknown reason 7f (code 7f)"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
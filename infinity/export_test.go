@@ -0,0 +1,8 @@
+package infinity
+
+import "context"
+
+// PollTableContext reads table at the priority of background polls.
+func (b *Bus) PollTableContext(ctx context.Context, dst uint16, table Table) error {
+	return b.readTable(ctx, dst, table, priorityPoll)
+}
@@ -23,6 +23,9 @@ const (
 	ReadList        = uint8(0x75)
 )
 
+// maxFrameData is the most data a frame can carry, its length being a byte.
+const maxFrameData = 255
+
 var crcConfig = &crc16.Conf{
 	Poly: 0x8005, BitRev: true,
 	IniVal: 0x0, FinVal: 0x0,
@@ -37,11 +40,37 @@ type Frame struct {
 	data    []byte
 }
 
-var writeAck = Frame{
-	src:  DevSAM,
-	dst:  DevTSTAT,
-	op:   Ack06,
-	data: []byte{0x00},
+// NewFrame builds a frame sent from src to dst.
+func NewFrame(src uint16, dst uint16, op uint8, data []byte) Frame {
+	return Frame{src: src, dst: dst, op: op, data: data}
+}
+
+// DecodeFrame decodes a complete frame, including its checksum, from buf.
+func DecodeFrame(buf []byte) (Frame, bool) {
+	f := Frame{}
+	ok := f.decode(buf)
+	return f, ok
+}
+
+func (f Frame) Src() uint16 {
+	return f.src
+}
+
+func (f Frame) Dst() uint16 {
+	return f.dst
+}
+
+func (f Frame) Op() uint8 {
+	return f.op
+}
+
+func (f Frame) Data() []byte {
+	return f.data
+}
+
+// Encode returns the wire representation of the frame.
+func (f Frame) Encode() []byte {
+	return f.encode()
 }
 
 func checksum(b []byte) []byte {
@@ -93,7 +122,7 @@ func opToString(op uint8) string {
 
 func (frame *Frame) encode() []byte {
 	// b := make([]byte, 10 + len(frame.data))
-	if len(frame.data) > 255 {
+	if len(frame.data) > maxFrameData {
 		panic("frame data too large")
 	}
 
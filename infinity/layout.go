@@ -0,0 +1,276 @@
+package infinity
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FieldType is how the bytes of a field are interpreted.
+type FieldType string
+
+const (
+	FieldUint8  FieldType = "uint8"
+	FieldInt8   FieldType = "int8"
+	FieldUint16 FieldType = "uint16"
+	FieldInt16  FieldType = "int16"
+	// Fixed length, NUL padded text
+	FieldString FieldType = "string"
+	FieldBytes  FieldType = "bytes"
+)
+
+// Field describes one field of a table.
+type Field struct {
+	Name   string
+	Offset int
+	Type   FieldType
+	// Length in bytes of string and bytes fields
+	Length int
+	// Number of consecutive elements, such as one per zone.  Zero and one
+	// both mean a single value.
+	Count int
+	// Decoded values are the raw value divided by Scale, if set
+	Scale float64
+	// Write flag bit allowing a write to change the field, zero if the field
+	// can't be written
+	Flag byte
+	// Names of the values of an enumerated field
+	Enum map[int64]string
+}
+
+// size returns the size of a single element of the field.
+func (f Field) size() int {
+	switch f.Type {
+	case FieldUint8, FieldInt8:
+		return 1
+	case FieldUint16, FieldInt16:
+		return 2
+	default:
+		return f.Length
+	}
+}
+
+func (f Field) count() int {
+	return max(f.Count, 1)
+}
+
+// Span returns the offset and length of the bytes holding the field.
+func (f Field) Span() (offset int, length int) {
+	return f.Offset, f.size() * f.count()
+}
+
+// value decodes element i of the field from data.
+func (f Field) value(data []byte, i int) any {
+	start := f.Offset + i*f.size()
+	b := data[start : start+f.size()]
+
+	var n int64
+	switch f.Type {
+	case FieldUint8:
+		n = int64(b[0])
+	case FieldInt8:
+		n = int64(int8(b[0]))
+	case FieldUint16:
+		n = int64(binary.BigEndian.Uint16(b))
+	case FieldInt16:
+		n = int64(int16(binary.BigEndian.Uint16(b)))
+	case FieldString:
+		return trimString(b)
+	default:
+		return fmt.Sprintf("%x", b)
+	}
+
+	if name, ok := f.Enum[n]; ok {
+		return name
+	}
+	if f.Scale != 0 {
+		return float64(n) / f.Scale
+	}
+	return n
+}
+
+// Decode returns the value of the field in data: a number, a string, or a
+// slice of them if the field has several elements.  Enumerated values are
+// replaced by their names, when known.
+func (f Field) Decode(data []byte) (any, error) {
+	offset, length := f.Span()
+	if offset+length > len(data) {
+		return nil, fmt.Errorf("field %s needs %d bytes, got %d", f.Name, offset+length, len(data))
+	}
+	if f.count() == 1 {
+		return f.value(data, 0), nil
+	}
+	values := make([]any, f.count())
+	for i := range values {
+		values[i] = f.value(data, i)
+	}
+	return values, nil
+}
+
+// Length of the header between the address of a table and its fields, in
+// frames carrying the table
+const tableHeaderLength = 3
+
+// Layout describes the fields of a table.
+type Layout struct {
+	Name string
+	Addr TableAddr
+	// Headerless tables have their fields right after the table address,
+	// without the header thermostat tables have
+	Headerless bool
+	Size       int
+	Fields     []Field
+}
+
+// FieldValue is a decoded field.
+type FieldValue struct {
+	Name  string `json:"name"`
+	Value any    `json:"value"`
+}
+
+// Field returns the field called name.
+func (l *Layout) Field(name string) (Field, bool) {
+	for _, f := range l.Fields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Field{}, false
+}
+
+// Flags returns the write flags allowing a write to change the named fields.
+// Naming a field that doesn't exist or can't be written is a programming
+// error, so it panics.
+func (l *Layout) Flags(names ...string) byte {
+	flags := byte(0)
+	for _, name := range names {
+		f, ok := l.Field(name)
+		if !ok || f.Flag == 0 {
+			panic(fmt.Sprintf("table %x has no writable field %s", l.Addr[:], name))
+		}
+		flags |= f.Flag
+	}
+	return flags
+}
+
+// Writable reports whether any field of the table has a write flag.
+func (l *Layout) Writable() bool {
+	for _, f := range l.Fields {
+		if f.Flag != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldData returns the part of body, the data following the table address
+// in a frame, that holds the fields of the table.
+func (l *Layout) fieldData(body []byte) []byte {
+	if l.Headerless {
+		return body
+	}
+	return body[min(tableHeaderLength, len(body)):]
+}
+
+// Decode decodes every field of the table found in data, in order.  Fields
+// beyond the end of data are left out.
+func (l *Layout) Decode(data []byte) []FieldValue {
+	values := []FieldValue{}
+	for _, f := range l.Fields {
+		if v, err := f.Decode(data); err == nil {
+			values = append(values, FieldValue{Name: f.Name, Value: v})
+		}
+	}
+	return values
+}
+
+var layouts sync.Map // reflect.Type -> *Layout
+
+// LayoutOf returns the layout of table t, derived from the struct declaring
+// it.  Fields are laid out in order as encoding/binary does.  Arrays hold one
+// element per zone or similar, [N]byte arrays are strings when tagged with
+// `infinity:"string"`, and a field may be tagged with its write flag and
+// scale, as in `infinity:"flag=0x04,scale=16"`.  Tables with a headerless
+// method are headerless.
+func LayoutOf(t Table) *Layout {
+	typ := reflect.TypeOf(t)
+	if typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if l, ok := layouts.Load(typ); ok {
+		return l.(*Layout)
+	}
+
+	l := &Layout{Name: typ.Name(), Addr: t.addr()}
+	_, l.Headerless = t.(headerlessTable)
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		f := Field{Name: sf.Name, Offset: l.Size}
+
+		ft := sf.Type
+		if ft.Kind() == reflect.Array {
+			f.Count = ft.Len()
+			ft = ft.Elem()
+		}
+
+		isString := false
+		for _, opt := range strings.Split(sf.Tag.Get("infinity"), ",") {
+			key, value, _ := strings.Cut(opt, "=")
+			switch key {
+			case "":
+			case "string":
+				isString = true
+			case "flag":
+				flag, err := strconv.ParseUint(value, 0, 8)
+				if err != nil {
+					panic(fmt.Sprintf("%s.%s: bad flag %q", typ.Name(), sf.Name, value))
+				}
+				f.Flag = byte(flag)
+			case "scale":
+				scale, err := strconv.ParseFloat(value, 64)
+				if err != nil {
+					panic(fmt.Sprintf("%s.%s: bad scale %q", typ.Name(), sf.Name, value))
+				}
+				f.Scale = scale
+			default:
+				panic(fmt.Sprintf("%s.%s: unknown layout option %q", typ.Name(), sf.Name, opt))
+			}
+		}
+
+		switch {
+		case isString:
+			// A string is an array of bytes, several of them an array of
+			// arrays
+			if f.Count > 0 && ft.Kind() == reflect.Uint8 {
+				f.Count = 0
+				ft = sf.Type
+			}
+			f.Type = FieldString
+			f.Length = ft.Len()
+		case ft.Kind() == reflect.Uint8:
+			f.Type = FieldUint8
+		case ft.Kind() == reflect.Int8:
+			f.Type = FieldInt8
+		case ft.Kind() == reflect.Uint16:
+			f.Type = FieldUint16
+		case ft.Kind() == reflect.Int16:
+			f.Type = FieldInt16
+		default:
+			panic(fmt.Sprintf("%s.%s: unsupported field type %s", typ.Name(), sf.Name, sf.Type))
+		}
+
+		l.Fields = append(l.Fields, f)
+		_, length := f.Span()
+		l.Size += length
+	}
+
+	if l.Size != binary.Size(reflect.New(typ).Interface()) {
+		panic(fmt.Sprintf("%s: layout size %d doesn't match encoded size", typ.Name(), l.Size))
+	}
+
+	layouts.Store(typ, l)
+	return l
+}
@@ -0,0 +1,264 @@
+package infinity
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultSilenceThreshold is how long the bus may go without data before
+	// the port is reopened.  The thermostat talks several times a second, so
+	// silence means the interface has hung.
+	defaultSilenceThreshold = 5 * time.Second
+	// Reopen attempts back off exponentially from reopenDelay up to
+	// maxReopenDelay.
+	maxReopenDelay = time.Minute
+	// Consecutive failures after which the link is considered failed and the
+	// failure hook runs.
+	defaultFailureThreshold = 5
+	hookTimeout             = 30 * time.Second
+)
+
+// LinkState describes the connection to the bus.
+type LinkState string
+
+const (
+	// The port is open and receiving data
+	LinkConnected LinkState = "connected"
+	// No data arrived for longer than the silence threshold
+	LinkSilent LinkState = "silent"
+	// The port is being reopened after an error or silence
+	LinkReopening LinkState = "reopening"
+	// Reopening has failed repeatedly; it is still retried
+	LinkFailed LinkState = "failed"
+)
+
+// LinkStatus describes the state of the connection to the bus.
+type LinkStatus struct {
+	State LinkState `json:"state"`
+	Since time.Time `json:"since"`
+	// When data was last received
+	LastData time.Time `json:"lastData"`
+	// Consecutive failures since data was last received
+	Failures  int    `json:"failures"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+// WithSilenceThreshold sets how long the bus may go without data before the
+// port is reopened.  Zero disables the check.
+func WithSilenceThreshold(d time.Duration) BusOption {
+	return func(b *Bus) {
+		b.link.silence = d
+	}
+}
+
+// WithFailureHook runs command through the shell whenever the link has
+// failed failures times in a row, for example to power cycle a USB hub.
+func WithFailureHook(command string, failures int) BusOption {
+	return func(b *Bus) {
+		b.link.hook = command
+		b.link.threshold = failures
+	}
+}
+
+// link tracks the state of the connection to the bus.
+type link struct {
+	device    string
+	silence   time.Duration
+	threshold int
+	hook      string
+
+	mu       sync.Mutex
+	status   LinkStatus
+	open     bool
+	watchers []func(LinkStatus)
+}
+
+func newLink() *link {
+	return &link{
+		silence:   defaultSilenceThreshold,
+		threshold: defaultFailureThreshold,
+		status:    LinkStatus{State: LinkReopening, Since: time.Now()},
+	}
+}
+
+// set changes the state, notifying watchers of changes.  l.mu must be held;
+// it is released on return.
+func (l *link) set(state LinkState, now time.Time) {
+	changed := l.status.State != state
+	if changed {
+		l.status.State = state
+		l.status.Since = now
+	}
+	status := l.status
+	watchers := l.watchers
+	l.mu.Unlock()
+
+	if changed {
+		log.Infof("bus link is %s", state)
+		for _, w := range watchers {
+			w(status)
+		}
+	}
+}
+
+// failed reports whether failures have piled up.  l.mu must be held.
+func (l *link) failed() bool {
+	return l.threshold > 0 && l.status.Failures >= l.threshold
+}
+
+// opened notes that the port was opened.
+func (l *link) opened(now time.Time) {
+	l.mu.Lock()
+	l.open = true
+	l.status.LastData = now
+	if l.failed() {
+		// Still failed until data shows up
+		l.mu.Unlock()
+		return
+	}
+	l.set(LinkConnected, now)
+}
+
+// received notes that data arrived.
+func (l *link) received(now time.Time) {
+	l.mu.Lock()
+	l.status.LastData = now
+	l.status.Failures = 0
+	l.status.LastError = ""
+	l.set(LinkConnected, now)
+}
+
+// lost notes that the port failed or went silent.  Only the first report of
+// a connection going away counts, the close that follows it is expected.
+func (l *link) lost(state LinkState, err error, now time.Time) {
+	l.mu.Lock()
+	if !l.open {
+		l.mu.Unlock()
+		return
+	}
+	l.open = false
+	if err != nil {
+		l.status.LastError = err.Error()
+	}
+	l.failure(state, now)
+}
+
+// reopening notes that the port is about to be reopened.
+func (l *link) reopening(now time.Time) {
+	l.mu.Lock()
+	if l.failed() {
+		l.mu.Unlock()
+		return
+	}
+	l.set(LinkReopening, now)
+}
+
+// openFailed notes a failed attempt to reopen the port.
+func (l *link) openFailed(err error, now time.Time) {
+	l.mu.Lock()
+	l.status.LastError = err.Error()
+	l.failure(LinkReopening, now)
+}
+
+// failure counts a failure and moves to state, or to LinkFailed once failures
+// pile up.  l.mu must be held; it is released on return.
+func (l *link) failure(state LinkState, now time.Time) {
+	l.status.Failures++
+	failures := l.status.Failures
+	if l.failed() {
+		state = LinkFailed
+		if l.hook != "" && failures%l.threshold == 0 {
+			go l.runHook(failures)
+		}
+	}
+	l.set(state, now)
+}
+
+// silentAt reports whether no data has arrived for longer than the silence
+// threshold.
+func (l *link) silentAt(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.silence > 0 && l.open && now.Sub(l.status.LastData) > l.silence
+}
+
+// backoff returns how long to wait before reopening the port.
+func (l *link) backoff() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.status.Failures == 0 {
+		return 0
+	}
+	d := reopenDelay << min(l.status.Failures-1, 10)
+	return min(d, maxReopenDelay)
+}
+
+func (l *link) runHook(failures int) {
+	log.Warnf("bus link failed %d times, running failure hook: %s", failures, l.hook)
+
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", l.hook)
+	cmd.Env = append(os.Environ(),
+		"INFINITIVE_DEVICE="+l.device,
+		fmt.Sprintf("INFINITIVE_FAILURES=%d", failures),
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		log.Errorf("failure hook failed: %s: %s", err.Error(), out)
+		return
+	}
+	log.Infof("failure hook finished: %s", out)
+}
+
+func (l *link) get() LinkStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.status
+}
+
+// watchdog closes the port when the bus goes silent, making the reader reopen
+// it.
+func (b *Bus) watchdog() {
+	if b.link.silence <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(max(b.link.silence/4, 100*time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if port := b.currentPort(); port != nil && b.link.silentAt(now) {
+				log.Warnf("no data received from the bus in %s, reopening it", b.link.silence)
+				b.link.lost(LinkSilent, nil, now)
+				b.closePort(port)
+			}
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// Link returns the state of the connection to the bus.
+func (b *Bus) Link() LinkStatus {
+	return b.link.get()
+}
+
+// OnLinkChange registers f to be called whenever the state of the connection
+// to the bus changes.
+func (b *Bus) OnLinkChange(f func(LinkStatus)) {
+	b.link.mu.Lock()
+	b.link.watchers = append(b.link.watchers, f)
+	b.link.mu.Unlock()
+}
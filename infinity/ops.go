@@ -0,0 +1,98 @@
+package infinity
+
+import (
+	"context"
+	"strings"
+)
+
+// requestOps are the operations that may be sent with Request, and the
+// priority they are sent at.  Operations changing a device's state are
+// treated like table writes.
+var requestOps = map[uint8]priority{
+	ReadTableBlock:  priorityRead,
+	WriteTableBlock: priorityWrite,
+	ChangeTableName: priorityWrite,
+	ReadObjectData:  priorityRead,
+	ReadVariable:    priorityRead,
+	WriteVariable:   priorityWrite,
+	AutoVariable:    priorityWrite,
+	ReadList:        priorityRead,
+}
+
+// ChangesState reports whether op changes a device's state, and so is sent
+// like a table write.
+func ChangesState(op uint8) bool {
+	return requestOps[op] == priorityWrite
+}
+
+// ParseOp returns the operation named name, as printed in frames (e.g.
+// "RDVAR"), ignoring case.
+func ParseOp(name string) (uint8, bool) {
+	for op, s := range opsToString {
+		if s != "" && strings.EqualFold(s, name) {
+			return uint8(op), true
+		}
+	}
+	return 0, false
+}
+
+// RequestContext sends an arbitrary request to dst and returns the data
+// carried by the response.  Only operations a device responds to are
+// accepted.
+func (b *Bus) RequestContext(ctx context.Context, dst uint16, op uint8, data []byte) ([]byte, error) {
+	prio, ok := requestOps[op]
+	if !ok {
+		return nil, invalidArgument("operation %s can't be requested", opToString(op))
+	}
+	if len(data) == 0 || len(data) > maxFrameData {
+		return nil, invalidArgument("request data must be 1 to %d bytes", maxFrameData)
+	}
+
+	res, err := b.exchange(ctx, dst, op, data, prio)
+	if err != nil {
+		return nil, err
+	}
+	return res.data, nil
+}
+
+// ReadVariableContext reads the variable at addr from dst (RDVAR), returning
+// the response data.
+func (b *Bus) ReadVariableContext(ctx context.Context, dst uint16, addr []byte) ([]byte, error) {
+	return b.RequestContext(ctx, dst, ReadVariable, addr)
+}
+
+// ForceVariableContext overrides the variable at addr of dst with value
+// (FORCE) until it is handed back with AutoVariableContext.
+func (b *Bus) ForceVariableContext(ctx context.Context, dst uint16, addr []byte, value []byte) error {
+	if len(addr) == 0 {
+		return invalidArgument("variable address must not be empty")
+	}
+	data := append(append([]byte{}, addr...), value...)
+	_, err := b.RequestContext(ctx, dst, WriteVariable, data)
+	return err
+}
+
+// AutoVariableContext returns the variable at addr of dst to the device's
+// own control (AUTO), undoing a FORCE.
+func (b *Bus) AutoVariableContext(ctx context.Context, dst uint16, addr []byte) error {
+	_, err := b.RequestContext(ctx, dst, AutoVariable, addr)
+	return err
+}
+
+// ReadObjectDataContext reads the object identified by object from dst
+// (OBJRD), returning the response data.
+func (b *Bus) ReadObjectDataContext(ctx context.Context, dst uint16, object []byte) ([]byte, error) {
+	return b.RequestContext(ctx, dst, ReadObjectData, object)
+}
+
+// ReadListContext reads the list identified by list from dst (LIST),
+// returning the response data.
+func (b *Bus) ReadListContext(ctx context.Context, dst uint16, list []byte) ([]byte, error) {
+	return b.RequestContext(ctx, dst, ReadList, list)
+}
+
+// ChangeTableNameContext sends a CHGTBN request carrying data to dst.
+func (b *Bus) ChangeTableNameContext(ctx context.Context, dst uint16, data []byte) error {
+	_, err := b.RequestContext(ctx, dst, ChangeTableName, data)
+	return err
+}
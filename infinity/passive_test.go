@@ -0,0 +1,60 @@
+package infinity_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/acd/infinitive/infinity"
+	"github.com/acd/infinitive/infinity/bustest"
+)
+
+func TestPassiveReadsFromSnapshots(t *testing.T) {
+	fb := bustest.NewBus()
+	bus := newTestBus(t, fb, infinity.WithPassive())
+	ctx := context.Background()
+
+	var sent atomic.Int32
+	bus.MonitorSent(func(f infinity.Frame) { sent.Add(1) })
+
+	params := infinity.TStatCurrentParams{}
+	if err := bus.ReadTableContext(ctx, infinity.DevTSTAT, &params); !errors.Is(err, infinity.ErrNotSeen) {
+		t.Fatalf("read before the table was seen: got %v, want ErrNotSeen", err)
+	}
+
+	// The thermostat answering someone else's read
+	addr := infinity.AddrOf(params)
+	payload := make([]byte, 6+binary.Size(params))
+	copy(payload, addr[:])
+	payload[6], payload[7] = 72, 68
+	fb.Inject(infinity.DevTSTAT, 0x9202, infinity.Ack06, payload)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		err := bus.ReadTableContext(ctx, infinity.DevTSTAT, &params)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, infinity.ErrNotSeen) || time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if params.CurrentTemp[0] != 72 || params.CurrentTemp[1] != 68 {
+		t.Fatalf("current temps = %v", params.CurrentTemp)
+	}
+
+	err := bus.WriteTableContext(ctx, infinity.DevTSTAT, params, infinity.LayoutOf(params).Flags("Mode"))
+	if !errors.Is(err, infinity.ErrReadOnly) {
+		t.Fatalf("write: got %v, want ErrReadOnly", err)
+	}
+	if _, err := bus.ReadVariableContext(ctx, infinity.DevTSTAT, []byte{0x00, 0x01}); !errors.Is(err, infinity.ErrPassive) {
+		t.Fatalf("variable read: got %v, want ErrPassive", err)
+	}
+	if n := sent.Load(); n != 0 || len(fb.Thermostat.Writes()) != 0 {
+		t.Fatalf("passive bus transmitted %d frames", n)
+	}
+}
@@ -0,0 +1,133 @@
+package infinity
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// priority orders requests waiting for the bus.  Higher priorities are always
+// served first.
+type priority int
+
+const (
+	priorityPoll  priority = iota // background polling
+	priorityRead                  // reads on behalf of a user
+	priorityWrite                 // writes on behalf of a user
+	numPriorities
+)
+
+// Bound on the number of requests waiting for the bus.  Once it is reached
+// new requests wait for room until their deadline.
+const queueLimit = 16
+
+// requestDeadlines bounds how long a request may take, including time spent
+// waiting in the queue.  Polls are refreshed every second, so there is no
+// point letting a stale one linger.
+var requestDeadlines = [numPriorities]time.Duration{
+	priorityPoll:  2 * time.Second,
+	priorityRead:  5 * time.Second,
+	priorityWrite: 5 * time.Second,
+}
+
+// scheduler queues actions for the broker by priority, first come first
+// served within a priority.
+type scheduler struct {
+	mu     sync.Mutex
+	queues [numPriorities][]*Action
+	// Holds one token per free queue slot
+	slots chan struct{}
+	// Holds one token per queued action
+	ready chan struct{}
+	// Closed once the scheduler is closed
+	done   chan struct{}
+	closed bool
+}
+
+func newScheduler() *scheduler {
+	s := &scheduler{
+		slots: make(chan struct{}, queueLimit),
+		ready: make(chan struct{}, queueLimit),
+		done:  make(chan struct{}),
+	}
+	for i := 0; i < queueLimit; i++ {
+		s.slots <- struct{}{}
+	}
+	return s
+}
+
+// push queues action, waiting for room in the queue until the action's
+// deadline or until its context is done.
+func (s *scheduler) push(action *Action) error {
+	timer := time.NewTimer(time.Until(action.deadline))
+	defer timer.Stop()
+
+	select {
+	case <-s.slots:
+	case <-timer.C:
+		return fmt.Errorf("%w: bus queue full", ErrTimeout)
+	case <-action.ctx.Done():
+		return action.ctx.Err()
+	case <-s.done:
+		return ErrClosed
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		s.slots <- struct{}{}
+		return ErrClosed
+	}
+	s.queues[action.priority] = append(s.queues[action.priority], action)
+	s.mu.Unlock()
+
+	s.ready <- struct{}{}
+	return nil
+}
+
+// pop waits for an action and returns the oldest one with the highest
+// priority, or nil once the scheduler is closed.
+func (s *scheduler) pop() *Action {
+	select {
+	case <-s.ready:
+	case <-s.done:
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	for p := numPriorities - 1; p >= 0; p-- {
+		if q := s.queues[p]; len(q) > 0 {
+			action := q[0]
+			q[0] = nil
+			s.queues[p] = q[1:]
+			s.slots <- struct{}{}
+			return action
+		}
+	}
+	panic("scheduler ready with no queued actions")
+}
+
+// close stops the scheduler, returning the actions still queued.
+func (s *scheduler) close() []*Action {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+
+	var actions []*Action
+	for p := numPriorities - 1; p >= 0; p-- {
+		actions = append(actions, s.queues[p]...)
+		s.queues[p] = nil
+	}
+	return actions
+}
@@ -0,0 +1,80 @@
+package infinity_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/acd/infinitive/infinity"
+	"github.com/acd/infinitive/infinity/bustest"
+)
+
+func TestWritesBeatPolls(t *testing.T) {
+	fb := bustest.NewBus()
+	bus := newTestBus(t, fb)
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var sent []infinity.Frame
+	bus.MonitorSent(func(f infinity.Frame) {
+		mu.Lock()
+		sent = append(sent, f)
+		mu.Unlock()
+	})
+
+	// Keep the bus busy retrying a read whose first response is lost, while
+	// polls and then a write queue up behind it
+	fb.InjectFaults(bustest.Drop)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := bus.ReadTableContext(ctx, infinity.DevTSTAT, &infinity.TStatSettings{}); err != nil {
+			t.Error(err)
+		}
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := bus.PollTableContext(ctx, infinity.DevTSTAT, &infinity.TStatCurrentParams{}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	params := infinity.TStatZoneParams{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := bus.WriteTableContext(ctx, infinity.DevTSTAT, params, infinity.LayoutOf(params).Flags("HeatSetpoint")); err != nil {
+			t.Error(err)
+		}
+	}()
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	ops := []uint8{}
+	for _, f := range sent {
+		ops = append(ops, f.Op())
+	}
+	// The read and its retransmission, then the write, then the polls
+	want := []uint8{
+		infinity.ReadTableBlock, infinity.ReadTableBlock,
+		infinity.WriteTableBlock,
+		infinity.ReadTableBlock, infinity.ReadTableBlock, infinity.ReadTableBlock,
+	}
+	if len(ops) != len(want) {
+		t.Fatalf("sent ops %x, want %x", ops, want)
+	}
+	for i := range want {
+		if ops[i] != want[i] {
+			t.Fatalf("sent ops %x, want %x", ops, want)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package infinity
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the response latency histogram.
+// Responses slower than the last bound fall into a final overflow bucket.
+var latencyBuckets = []time.Duration{
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// BusStats summarizes the health of the bus since it was opened.
+type BusStats struct {
+	// Frames successfully decoded
+	Frames uint64 `json:"frames"`
+	// Candidate frames failing checksum validation, including those tried
+	// while resynchronizing
+	ChecksumFailures uint64 `json:"checksumFailures"`
+	// Runs of bytes discarded to resynchronize, and their total length
+	Resyncs        uint64 `json:"resyncs"`
+	BytesDiscarded uint64 `json:"bytesDiscarded"`
+	// Requests retransmitted for lack of a response, and requests abandoned
+	// after exhausting their retries
+	Retransmits uint64 `json:"retransmits"`
+	Timeouts    uint64 `json:"timeouts"`
+	// Attempts to reopen the port after an error, and how many failed
+	Reopens         uint64                 `json:"reopens"`
+	ReopenFailures  uint64                 `json:"reopenFailures"`
+	ResponseLatency LatencyStats           `json:"responseLatency"`
+	LastFrame       time.Time              `json:"lastFrame"`
+	Flows           []FlowStats            `json:"flows"`
+	Devices         map[string]DeviceStats `json:"devices"`
+}
+
+// FlowStats counts the frames seen with one combination of source,
+// destination and operation.
+type FlowStats struct {
+	Src   string `json:"src"`
+	Dst   string `json:"dst"`
+	Op    string `json:"op"`
+	Count uint64 `json:"count"`
+}
+
+// DeviceStats summarizes the traffic of a single bus address.
+type DeviceStats struct {
+	// Frames sent and received by the device, by operation
+	Sent     map[string]uint64 `json:"sent"`
+	Received map[string]uint64 `json:"received"`
+	// Our requests to the device that were retransmitted or timed out
+	Retransmits     uint64       `json:"retransmits"`
+	Timeouts        uint64       `json:"timeouts"`
+	ResponseLatency LatencyStats `json:"responseLatency"`
+	LastSeen        time.Time    `json:"lastSeen"`
+}
+
+// LatencyStats describes the distribution of response times to our requests.
+type LatencyStats struct {
+	Count   uint64          `json:"count"`
+	MinMs   float64         `json:"minMs"`
+	MeanMs  float64         `json:"meanMs"`
+	MaxMs   float64         `json:"maxMs"`
+	Buckets []LatencyBucket `json:"buckets"`
+}
+
+// LatencyBucket counts the responses slower than the previous bucket's bound
+// and no slower than Le.
+type LatencyBucket struct {
+	Le    string `json:"le"`
+	Count uint64 `json:"count"`
+}
+
+type latency struct {
+	count   uint64
+	sum     time.Duration
+	min     time.Duration
+	max     time.Duration
+	buckets [8]uint64 // len(latencyBuckets) + 1
+}
+
+func (l *latency) observe(d time.Duration) {
+	if l.count == 0 || d < l.min {
+		l.min = d
+	}
+	if d > l.max {
+		l.max = d
+	}
+	l.count++
+	l.sum += d
+
+	i := sort.Search(len(latencyBuckets), func(i int) bool { return d <= latencyBuckets[i] })
+	l.buckets[i]++
+}
+
+func (l *latency) stats() LatencyStats {
+	s := LatencyStats{Count: l.count}
+	if l.count > 0 {
+		s.MinMs = ms(l.min)
+		s.MeanMs = ms(l.sum / time.Duration(l.count))
+		s.MaxMs = ms(l.max)
+	}
+	for i, c := range l.buckets {
+		le := "+Inf"
+		if i < len(latencyBuckets) {
+			le = latencyBuckets[i].String()
+		}
+		s.Buckets = append(s.Buckets, LatencyBucket{Le: le, Count: c})
+	}
+	return s
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+type flow struct {
+	src uint16
+	dst uint16
+	op  uint8
+}
+
+type deviceStats struct {
+	sent        map[uint8]uint64
+	received    map[uint8]uint64
+	retransmits uint64
+	timeouts    uint64
+	latency     latency
+	lastSeen    time.Time
+}
+
+type busStats struct {
+	mu               sync.Mutex
+	frames           uint64
+	checksumFailures uint64
+	resyncs          uint64
+	bytesDiscarded   uint64
+	retransmits      uint64
+	timeouts         uint64
+	reopens          uint64
+	reopenFailures   uint64
+	latency          latency
+	lastFrame        time.Time
+	flows            map[flow]uint64
+	devices          map[uint16]*deviceStats
+}
+
+func newBusStats() *busStats {
+	return &busStats{
+		flows:   make(map[flow]uint64),
+		devices: make(map[uint16]*deviceStats),
+	}
+}
+
+// device returns the stats for addr, creating them if needed.  s.mu must be
+// held.
+func (s *busStats) device(addr uint16) *deviceStats {
+	d, ok := s.devices[addr]
+	if !ok {
+		d = &deviceStats{
+			sent:     make(map[uint8]uint64),
+			received: make(map[uint8]uint64),
+		}
+		s.devices[addr] = d
+	}
+	return d
+}
+
+func (s *busStats) frame(f Frame, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.frames++
+	s.lastFrame = now
+	s.flows[flow{f.src, f.dst, f.op}]++
+
+	src := s.device(f.src)
+	src.sent[f.op]++
+	src.lastSeen = now
+	s.device(f.dst).received[f.op]++
+}
+
+func (s *busStats) checksumFailure() {
+	s.mu.Lock()
+	s.checksumFailures++
+	s.mu.Unlock()
+}
+
+func (s *busStats) discarded(n int) {
+	if n == 0 {
+		return
+	}
+	s.mu.Lock()
+	s.resyncs++
+	s.bytesDiscarded += uint64(n)
+	s.mu.Unlock()
+}
+
+func (s *busStats) retransmit(dst uint16) {
+	s.mu.Lock()
+	s.retransmits++
+	s.device(dst).retransmits++
+	s.mu.Unlock()
+}
+
+func (s *busStats) timeout(dst uint16) {
+	s.mu.Lock()
+	s.timeouts++
+	s.device(dst).timeouts++
+	s.mu.Unlock()
+}
+
+func (s *busStats) response(src uint16, d time.Duration) {
+	s.mu.Lock()
+	s.latency.observe(d)
+	s.device(src).latency.observe(d)
+	s.mu.Unlock()
+}
+
+func (s *busStats) reopen(err error) {
+	s.mu.Lock()
+	s.reopens++
+	if err != nil {
+		s.reopenFailures++
+	}
+	s.mu.Unlock()
+}
+
+func (s *busStats) snapshot() BusStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bs := BusStats{
+		Frames:           s.frames,
+		ChecksumFailures: s.checksumFailures,
+		Resyncs:          s.resyncs,
+		BytesDiscarded:   s.bytesDiscarded,
+		Retransmits:      s.retransmits,
+		Timeouts:         s.timeouts,
+		Reopens:          s.reopens,
+		ReopenFailures:   s.reopenFailures,
+		ResponseLatency:  s.latency.stats(),
+		LastFrame:        s.lastFrame,
+		Flows:            []FlowStats{},
+		Devices:          make(map[string]DeviceStats),
+	}
+
+	flows := make([]flow, 0, len(s.flows))
+	for f := range s.flows {
+		flows = append(flows, f)
+	}
+	sort.Slice(flows, func(i, j int) bool {
+		a, b := flows[i], flows[j]
+		if a.src != b.src {
+			return a.src < b.src
+		}
+		if a.dst != b.dst {
+			return a.dst < b.dst
+		}
+		return a.op < b.op
+	})
+	for _, f := range flows {
+		bs.Flows = append(bs.Flows, FlowStats{
+			Src:   fmt.Sprintf("%04x", f.src),
+			Dst:   fmt.Sprintf("%04x", f.dst),
+			Op:    opToString(f.op),
+			Count: s.flows[f],
+		})
+	}
+
+	for addr, d := range s.devices {
+		ds := DeviceStats{
+			Sent:            make(map[string]uint64),
+			Received:        make(map[string]uint64),
+			Retransmits:     d.retransmits,
+			Timeouts:        d.timeouts,
+			ResponseLatency: d.latency.stats(),
+			LastSeen:        d.lastSeen,
+		}
+		for op, c := range d.sent {
+			ds.Sent[opToString(op)] = c
+		}
+		for op, c := range d.received {
+			ds.Received[opToString(op)] = c
+		}
+		bs.Devices[fmt.Sprintf("%04x", addr)] = ds
+	}
+
+	return bs
+}
+
+// Stats returns counters describing the health of the bus.
+func (b *Bus) Stats() BusStats {
+	return b.stats.snapshot()
+}
@@ -0,0 +1,88 @@
+package infinity
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBusStatsCounters(t *testing.T) {
+	s := newBusStats()
+	now := time.Date(2024, 1, 5, 17, 2, 11, 0, time.UTC)
+
+	s.frame(NewFrame(DevSAM, DevTSTAT, ReadTableBlock, nil), now)
+	s.frame(NewFrame(DevTSTAT, DevSAM, Ack06, nil), now.Add(time.Second))
+	s.frame(NewFrame(DevSAM, DevTSTAT, ReadTableBlock, nil), now.Add(2*time.Second))
+	s.checksumFailure()
+	s.checksumFailure()
+	s.discarded(0)
+	s.discarded(5)
+	s.discarded(2)
+	s.retransmit(DevTSTAT)
+	s.timeout(0x4001)
+	s.reopen(nil)
+	s.reopen(errors.New("no such device"))
+
+	bs := s.snapshot()
+	got := [...]uint64{bs.Frames, bs.ChecksumFailures, bs.Resyncs, bs.BytesDiscarded, bs.Retransmits, bs.Timeouts, bs.Reopens, bs.ReopenFailures}
+	if want := [...]uint64{3, 2, 2, 7, 1, 1, 2, 1}; got != want {
+		t.Errorf("frames, checksum failures, resyncs, bytes discarded, retransmits, timeouts, reopens, reopen failures = %v, want %v", got, want)
+	}
+	if !bs.LastFrame.Equal(now.Add(2 * time.Second)) {
+		t.Errorf("last frame at %v", bs.LastFrame)
+	}
+
+	wantFlows := []FlowStats{
+		{Src: "2001", Dst: "9201", Op: "ACK06", Count: 1},
+		{Src: "9201", Dst: "2001", Op: "READ", Count: 2},
+	}
+	if !reflect.DeepEqual(bs.Flows, wantFlows) {
+		t.Errorf("flows %+v, want %+v", bs.Flows, wantFlows)
+	}
+
+	tstat := bs.Devices["2001"]
+	if !reflect.DeepEqual(tstat.Sent, map[string]uint64{"ACK06": 1}) || !reflect.DeepEqual(tstat.Received, map[string]uint64{"READ": 2}) {
+		t.Errorf("thermostat sent %v and received %v", tstat.Sent, tstat.Received)
+	}
+	if tstat.Retransmits != 1 || tstat.Timeouts != 0 || !tstat.LastSeen.Equal(now.Add(time.Second)) {
+		t.Errorf("thermostat stats %+v", tstat)
+	}
+	if ah := bs.Devices["4001"]; ah.Timeouts != 1 || len(ah.Sent) != 0 || !ah.LastSeen.IsZero() {
+		t.Errorf("air handler stats %+v", ah)
+	}
+}
+
+func TestBusStatsLatency(t *testing.T) {
+	s := newBusStats()
+	for _, d := range []time.Duration{
+		5 * time.Millisecond,
+		10 * time.Millisecond,
+		11 * time.Millisecond,
+		200 * time.Millisecond,
+		3 * time.Second,
+	} {
+		s.response(DevTSTAT, d)
+	}
+
+	bs := s.snapshot()
+	l := bs.ResponseLatency
+	if l.Count != 5 || l.MinMs != 5 || l.MaxMs != 3000 || l.MeanMs != 645.2 {
+		t.Errorf("count %d, min %v, mean %v, max %v", l.Count, l.MinMs, l.MeanMs, l.MaxMs)
+	}
+	want := []LatencyBucket{
+		{"10ms", 2}, {"25ms", 1}, {"50ms", 0}, {"100ms", 0},
+		{"200ms", 1}, {"500ms", 0}, {"1s", 0}, {"+Inf", 1},
+	}
+	if !reflect.DeepEqual(l.Buckets, want) {
+		t.Errorf("buckets %+v, want %+v", l.Buckets, want)
+	}
+	if !reflect.DeepEqual(bs.Devices["2001"].ResponseLatency, l) {
+		t.Errorf("thermostat latency %+v differs from the bus latency", bs.Devices["2001"].ResponseLatency)
+	}
+
+	empty := newBusStats().snapshot().ResponseLatency
+	if empty.Count != 0 || empty.MinMs != 0 || len(empty.Buckets) != len(latencyBuckets)+1 {
+		t.Errorf("empty latency %+v", empty)
+	}
+}
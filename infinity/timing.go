@@ -0,0 +1,142 @@
+package infinity
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultIdleGap is how long the bus must be quiet before we transmit.
+	defaultIdleGap = 20 * time.Millisecond
+	// maxTransmitDelay bounds how long a transmission waits for a quiet bus,
+	// so a noisy bus can't starve us completely.
+	maxTransmitDelay = time.Second
+	// Thermostat frames further apart than burstGap belong to separate polling
+	// bursts.
+	burstGap = 250 * time.Millisecond
+	// burstGuard keeps our transmissions this far ahead of a predicted burst,
+	// leaving room for the device we address to respond.
+	burstGuard = 100 * time.Millisecond
+	// Number of bursts observed before their cadence is trusted.
+	minBurstSamples = 3
+	// Retries wait responseTimeout plus a random backoff of up to
+	// retryBackoffStep doubled on every attempt.
+	retryBackoffStep = 50 * time.Millisecond
+)
+
+// WithIdleGap sets how long the bus must be quiet before we transmit.
+func WithIdleGap(gap time.Duration) BusOption {
+	return func(b *Bus) {
+		b.timing.idleGap = gap
+	}
+}
+
+// timing tracks bus activity so we transmit when the bus is quiet.  Besides
+// waiting for an idle gap after the last byte received, it learns the period
+// and length of the thermostat's polling bursts and avoids the time slots
+// they are expected in.
+type timing struct {
+	idleGap time.Duration
+	// Our own address
+	self uint16
+
+	mu         sync.Mutex
+	lastRx     time.Time
+	burstStart time.Time
+	burstEnd   time.Time
+	period     time.Duration
+	duration   time.Duration
+	samples    int
+}
+
+func newTiming() *timing {
+	return &timing{idleGap: defaultIdleGap, self: DevSAM}
+}
+
+// received notes that bytes arrived from the bus.
+func (t *timing) received(now time.Time) {
+	t.mu.Lock()
+	t.lastRx = now
+	t.mu.Unlock()
+}
+
+// observe tracks the thermostat's conversations with other devices.  Our own
+// exchanges with the thermostat are ignored since we choose when they happen.
+func (t *timing) observe(f Frame, now time.Time) {
+	if f.src == t.self || f.dst == t.self || (f.src != DevTSTAT && f.dst != DevTSTAT) {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.burstEnd.IsZero() || now.Sub(t.burstEnd) > burstGap {
+		if !t.burstStart.IsZero() {
+			t.period = ewma(t.period, now.Sub(t.burstStart), t.samples)
+			t.duration = ewma(t.duration, t.burstEnd.Sub(t.burstStart), t.samples)
+			t.samples++
+		}
+		t.burstStart = now
+	}
+	t.burstEnd = now
+}
+
+func ewma(avg time.Duration, sample time.Duration, samples int) time.Duration {
+	if samples == 0 {
+		return sample
+	}
+	return avg + (sample-avg)/4
+}
+
+// delay returns how long to wait before transmitting at now.
+func (t *timing) delay(now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	wait := t.lastRx.Add(t.idleGap).Sub(now)
+
+	if t.samples >= minBurstSamples && t.period > 0 {
+		// Find the next burst that hasn't finished yet and stay clear of it
+		next := t.burstStart.Add(t.period)
+		for next.Add(t.duration).Before(now) {
+			next = next.Add(t.period)
+		}
+		if now.After(next.Add(-burstGuard)) {
+			if w := next.Add(t.duration + t.idleGap).Sub(now); w > wait {
+				wait = w
+			}
+		}
+	}
+
+	return min(wait, maxTransmitDelay)
+}
+
+// waitQuiet blocks until it is a good time to transmit or ctx is done.
+func (t *timing) waitQuiet(ctx context.Context) error {
+	deadline := time.Now().Add(maxTransmitDelay)
+	for {
+		now := time.Now()
+		wait := min(t.delay(now), deadline.Sub(now))
+		if wait <= 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// retryDelay returns how long to wait for a response before retransmitting
+// for the given attempt.  The random backoff keeps us from colliding with
+// the same traffic over and over.
+func retryDelay(tries int) time.Duration {
+	backoff := retryBackoffStep << min(tries, 5)
+	return responseTimeout + time.Duration(rand.Int63n(int64(backoff)))
+}
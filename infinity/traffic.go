@@ -0,0 +1,34 @@
+package infinity
+
+import "time"
+
+// BusFrame is a frame read from or sent on the bus, as streamed to clients
+// watching the bus.
+type BusFrame struct {
+	Time time.Time `json:"time"`
+	// Whether the frame was "received" or "sent" by us
+	Direction string   `json:"direction"`
+	Src       Endpoint `json:"src"`
+	Dst       Endpoint `json:"dst"`
+	Op        string   `json:"op"`
+	Data      string   `json:"data"`
+	// The table read or carried, with its fields when known
+	Table *DissectedTable `json:"table,omitempty"`
+	Nack  *DissectedNack  `json:"nack,omitempty"`
+	Alarm *DissectedAlarm `json:"alarm,omitempty"`
+}
+
+func (b *Bus) busFrame(f Frame, direction string, now time.Time) BusFrame {
+	d := b.definitions.dissectFrame(f)
+	return BusFrame{
+		Time:      now,
+		Direction: direction,
+		Src:       d.Src,
+		Dst:       d.Dst,
+		Op:        d.Op,
+		Data:      d.Data,
+		Table:     d.Table,
+		Nack:      d.Nack,
+		Alarm:     d.Alarm,
+	}
+}
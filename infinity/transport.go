@@ -0,0 +1,273 @@
+package infinity
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tarm/serial"
+)
+
+const dialTimeout = 5 * time.Second
+
+// openTransport opens the bus connection described by device.  In addition to
+// a local serial device path, device may be a tcp://host:port URL for a raw
+// TCP serial bridge (such as ser2net) or an rfc2217://host:port URL for a
+// bridge speaking the RFC 2217 telnet COM port control protocol.  Reads
+// return no data and no error after readTimeout without data.
+func openTransport(device string, readTimeout time.Duration) (Port, error) {
+	scheme, addr, found := strings.Cut(device, "://")
+	if found {
+		switch scheme {
+		case "tcp":
+			return dialTCP(addr, readTimeout)
+		case "rfc2217":
+			return dialRFC2217(addr, readTimeout)
+		}
+	}
+
+	c := &serial.Config{
+		Name:        device,
+		Baud:        38400,
+		ReadTimeout: readTimeout,
+	}
+	p, err := serial.OpenPort(c)
+	if err != nil {
+		return nil, err
+	}
+	return &serialPort{p}, nil
+}
+
+// serialPort is a local serial port.
+type serialPort struct {
+	*serial.Port
+}
+
+func (p *serialPort) Read(b []byte) (int, error) {
+	n, err := p.Port.Read(b)
+	// A read timing out looks like the end of the file
+	if n == 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// tcpPort is a raw TCP connection to a serial bridge.  Reads time out the same
+// way a local serial port does.
+type tcpPort struct {
+	net.Conn
+	readTimeout time.Duration
+}
+
+func dialTCP(addr string, readTimeout time.Duration) (*tcpPort, error) {
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &tcpPort{Conn: conn, readTimeout: readTimeout}, nil
+}
+
+func (p *tcpPort) Read(b []byte) (int, error) {
+	p.SetReadDeadline(time.Now().Add(p.readTimeout))
+	n, err := p.Conn.Read(b)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		err = nil
+	}
+	return n, err
+}
+
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+
+	telnetOptBinary  = 0
+	telnetOptSGA     = 3
+	telnetOptComPort = 44
+
+	comPortSetBaudRate = 1
+	comPortSetDataSize = 2
+	comPortSetParity   = 3
+	comPortSetStopSize = 4
+
+	comPortParityNone = 1
+	comPortStopSize1  = 1
+)
+
+const (
+	telnetStateData = iota
+	telnetStateIAC
+	telnetStateOption
+	telnetStateSB
+	telnetStateSBIAC
+)
+
+// rfc2217Port is a telnet connection to a serial bridge implementing the
+// RFC 2217 COM port control option.  Telnet commands are stripped from the
+// received stream and IAC bytes in transmitted data are escaped.
+type rfc2217Port struct {
+	*tcpPort
+	state   int
+	command byte
+	will    [256]bool
+	do      [256]bool
+	mu      sync.Mutex
+}
+
+func dialRFC2217(addr string, readTimeout time.Duration) (*rfc2217Port, error) {
+	tp, err := dialTCP(addr, readTimeout)
+	if err != nil {
+		return nil, err
+	}
+	p := &rfc2217Port{tcpPort: tp}
+
+	var b bytes.Buffer
+	p.negotiate(&b, telnetWILL, telnetOptBinary)
+	p.negotiate(&b, telnetDO, telnetOptBinary)
+	p.negotiate(&b, telnetWILL, telnetOptComPort)
+
+	baud := make([]byte, 4)
+	binary.BigEndian.PutUint32(baud, 38400)
+	comPortCommand(&b, comPortSetBaudRate, baud...)
+	comPortCommand(&b, comPortSetDataSize, 8)
+	comPortCommand(&b, comPortSetParity, comPortParityNone)
+	comPortCommand(&b, comPortSetStopSize, comPortStopSize1)
+
+	if err := p.writeRaw(b.Bytes()); err != nil {
+		tp.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func comPortCommand(b *bytes.Buffer, command byte, value ...byte) {
+	b.Write([]byte{telnetIAC, telnetSB, telnetOptComPort, command})
+	b.Write(escapeIAC(value))
+	b.Write([]byte{telnetIAC, telnetSE})
+}
+
+func escapeIAC(data []byte) []byte {
+	return bytes.ReplaceAll(data, []byte{telnetIAC}, []byte{telnetIAC, telnetIAC})
+}
+
+// negotiate appends a WILL or DO request for option to b unless it has already
+// been sent.
+func (p *rfc2217Port) negotiate(b *bytes.Buffer, command byte, option byte) {
+	sent := &p.will
+	if command == telnetDO {
+		sent = &p.do
+	}
+	if !sent[option] {
+		sent[option] = true
+		b.Write([]byte{telnetIAC, command, option})
+	}
+}
+
+func (p *rfc2217Port) writeRaw(b []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := p.Conn.Write(b)
+	return err
+}
+
+func (p *rfc2217Port) Write(b []byte) (int, error) {
+	if err := p.writeRaw(escapeIAC(b)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (p *rfc2217Port) Read(b []byte) (int, error) {
+	for {
+		read, err := p.tcpPort.Read(b)
+		n := p.filter(b[:read])
+		// Keep reading if all we got was telnet commands
+		if n > 0 || read == 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+// filter strips telnet commands from buf in place, answering option
+// negotiation as it goes, and returns the number of data bytes remaining.
+func (p *rfc2217Port) filter(buf []byte) int {
+	var reply bytes.Buffer
+	n := 0
+
+	for _, c := range buf {
+		switch p.state {
+		case telnetStateData:
+			if c == telnetIAC {
+				p.state = telnetStateIAC
+			} else {
+				buf[n] = c
+				n++
+			}
+		case telnetStateIAC:
+			switch c {
+			case telnetIAC:
+				buf[n] = c
+				n++
+				p.state = telnetStateData
+			case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+				p.command = c
+				p.state = telnetStateOption
+			case telnetSB:
+				p.state = telnetStateSB
+			default:
+				p.state = telnetStateData
+			}
+		case telnetStateOption:
+			p.reply(&reply, p.command, c)
+			p.state = telnetStateData
+		case telnetStateSB:
+			// Subnegotiation replies from the bridge only acknowledge the
+			// settings we requested, so they are discarded.
+			if c == telnetIAC {
+				p.state = telnetStateSBIAC
+			}
+		case telnetStateSBIAC:
+			if c == telnetSE {
+				p.state = telnetStateData
+			} else {
+				p.state = telnetStateSB
+			}
+		}
+	}
+
+	if reply.Len() > 0 {
+		if err := p.writeRaw(reply.Bytes()); err != nil {
+			log.Errorf("error writing telnet negotiation: %s", err.Error())
+		}
+	}
+
+	return n
+}
+
+func (p *rfc2217Port) reply(b *bytes.Buffer, command byte, option byte) {
+	switch command {
+	case telnetDO:
+		switch option {
+		case telnetOptBinary, telnetOptSGA, telnetOptComPort:
+			p.negotiate(b, telnetWILL, option)
+		default:
+			b.Write([]byte{telnetIAC, telnetWONT, option})
+		}
+	case telnetWILL:
+		switch option {
+		case telnetOptBinary, telnetOptSGA:
+			p.negotiate(b, telnetDO, option)
+		default:
+			b.Write([]byte{telnetIAC, telnetDONT, option})
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package infinity
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// recordingConn is a connection that records what is written to it.
+type recordingConn struct {
+	net.Conn
+	written bytes.Buffer
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	return c.written.Write(b)
+}
+
+func newTestRFC2217Port() (*rfc2217Port, *recordingConn) {
+	conn := &recordingConn{}
+	return &rfc2217Port{tcpPort: &tcpPort{Conn: conn}}, conn
+}
+
+func TestRFC2217Filter(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    [][]byte
+		data  []byte
+		reply []byte
+	}{
+		{
+			name: "data",
+			in:   [][]byte{{0x20, 0x01, 0x92}},
+			data: []byte{0x20, 0x01, 0x92},
+		},
+		{
+			name: "escaped IAC",
+			in:   [][]byte{{0x01, telnetIAC, telnetIAC, 0x02}},
+			data: []byte{0x01, telnetIAC, 0x02},
+		},
+		{
+			name:  "supported options",
+			in:    [][]byte{{telnetIAC, telnetDO, telnetOptComPort, 0x01, telnetIAC, telnetWILL, telnetOptSGA}},
+			data:  []byte{0x01},
+			reply: []byte{telnetIAC, telnetWILL, telnetOptComPort, telnetIAC, telnetDO, telnetOptSGA},
+		},
+		{
+			name:  "options answered once",
+			in:    [][]byte{{telnetIAC, telnetDO, telnetOptBinary, telnetIAC, telnetDO, telnetOptBinary}},
+			reply: []byte{telnetIAC, telnetWILL, telnetOptBinary},
+		},
+		{
+			name:  "unsupported options",
+			in:    [][]byte{{telnetIAC, telnetDO, 24, telnetIAC, telnetWILL, 1}},
+			reply: []byte{telnetIAC, telnetWONT, 24, telnetIAC, telnetDONT, 1},
+		},
+		{
+			name: "refusals ignored",
+			in:   [][]byte{{telnetIAC, telnetWONT, telnetOptSGA, telnetIAC, telnetDONT, telnetOptBinary, 0x03}},
+			data: []byte{0x03},
+		},
+		{
+			name: "subnegotiation",
+			in:   [][]byte{{0x01, telnetIAC, telnetSB, telnetOptComPort, 101, 0x00, 0x00, telnetIAC, telnetIAC, 0x00, telnetIAC, telnetSE, 0x02}},
+			data: []byte{0x01, 0x02},
+		},
+		{
+			name:  "commands split across reads",
+			in:    [][]byte{{0x01, telnetIAC}, {telnetDO}, {telnetOptSGA, 0x02, telnetIAC, telnetSB}, {telnetOptComPort, 101, telnetIAC}, {telnetSE, 0x03}},
+			data:  []byte{0x01, 0x02, 0x03},
+			reply: []byte{telnetIAC, telnetWILL, telnetOptSGA},
+		},
+	}
+
+	for _, tt := range tests {
+		p, conn := newTestRFC2217Port()
+		data := []byte{}
+		for _, in := range tt.in {
+			buf := append([]byte{}, in...)
+			data = append(data, buf[:p.filter(buf)]...)
+		}
+		if !bytes.Equal(data, tt.data) {
+			t.Errorf("%s: data %x, want %x", tt.name, data, tt.data)
+		}
+		if !bytes.Equal(conn.written.Bytes(), tt.reply) {
+			t.Errorf("%s: replied %x, want %x", tt.name, conn.written.Bytes(), tt.reply)
+		}
+	}
+}
+
+func TestRFC2217WriteEscapesIAC(t *testing.T) {
+	p, conn := newTestRFC2217Port()
+	n, err := p.Write([]byte{0x01, telnetIAC, 0x02})
+	if err != nil || n != 3 {
+		t.Fatalf("Write returned %d, %v", n, err)
+	}
+	if want := []byte{0x01, telnetIAC, telnetIAC, 0x02}; !bytes.Equal(conn.written.Bytes(), want) {
+		t.Errorf("wrote %x, want %x", conn.written.Bytes(), want)
+	}
+}
+
+func TestRFC2217Dial(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			received <- nil
+			return
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		var buf bytes.Buffer
+		io.Copy(&buf, conn)
+		received <- buf.Bytes()
+	}()
+
+	p, err := dialRFC2217(ln.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+
+	want := []byte{
+		telnetIAC, telnetWILL, telnetOptBinary,
+		telnetIAC, telnetDO, telnetOptBinary,
+		telnetIAC, telnetWILL, telnetOptComPort,
+		telnetIAC, telnetSB, telnetOptComPort, comPortSetBaudRate, 0x00, 0x00, 0x96, 0x00, telnetIAC, telnetSE,
+		telnetIAC, telnetSB, telnetOptComPort, comPortSetDataSize, 8, telnetIAC, telnetSE,
+		telnetIAC, telnetSB, telnetOptComPort, comPortSetParity, comPortParityNone, telnetIAC, telnetSE,
+		telnetIAC, telnetSB, telnetOptComPort, comPortSetStopSize, comPortStopSize1, telnetIAC, telnetSE,
+	}
+	if got := <-received; !bytes.Equal(got, want) {
+		t.Errorf("negotiated %x, want %x", got, want)
+	}
+}
@@ -44,55 +44,82 @@ app.factory('thermostatEvents', function ($websocket) {
 app.controller('thermostatController', function($scope, $http, $interval, $location, thermostatEvents) {
   $scope.tstat = {};
   $scope.blower = {};
+  $scope.alarms = [];
+  $scope.zones = [];
+  $scope.zone = 1;
+
+  // State of every zone, so switching zones doesn't wait for an update
+  var zoneStates = {};
 
   var $wsUrl = "ws://" + $location.host() + ":" + $location.port() + "/api/ws";
 
   thermostatEvents.start($wsUrl, function (msg) {
-    if (msg.source == "tstat") {
-       $scope.tstat = msg.data;
+    if (msg.source.indexOf("zone/") == 0) {
+       var zone = parseInt(msg.source.substring(5));
+       zoneStates[zone] = msg.data;
+       if (zone == $scope.zone) {
+         $scope.tstat = msg.data;
+       }
+    } else if (msg.source == "zones") {
+       $scope.zones = msg.data;
     } else if (msg.source == "blower") {
        $scope.blower = msg.data;
+    } else if (msg.source == "alarms") {
+       $scope.alarms = msg.data;
     }
   });
 
   // $scope.events = thermostatEvents;
 
+  $scope.error = null;
+
+  var showError = function(response) {
+    if (response.data && response.data.length > 0) {
+      $scope.error = response.data[0].error;
+    } else {
+      $scope.error = "Request failed: " + response.status;
+    }
+  };
+
+  var updateConfig = function(params, what) {
+    $http.put("/api/zone/" + $scope.zone + "/config", params).then(function(response) {
+      $scope.error = null;
+      console.log(what);
+    }, showError);
+  };
+
   $scope.refreshState = function () {
-     $http.get("/api/zone/1/config").then(function(response) {
+     $http.get("/api/zone/" + $scope.zone + "/config").then(function(response) {
       $scope.tstat = response.data;
     });
   };
 
+  $scope.selectZone = function(zone) {
+    $scope.zone = zone;
+    $scope.tstat = zoneStates[zone] || {};
+    $scope.refreshState();
+  }
+
   $scope.setFanSpeed = function(speed) {
-    $http.put("/api/zone/1/config", { "fanMode": speed }).then(function(response) {
-      console.log("set fan speed") ;
-    });
+    updateConfig({ "fanMode": speed }, "set fan speed");
   }
 
   $scope.setMode = function(mode) {
-    $http.put("/api/zone/1/config", { "mode": mode }).then(function(response) {
-      console.log("set fan speed") ;
-    });
+    updateConfig({ "mode": mode }, "set mode");
   }
 
   $scope.setHold = function(hold) {
-    $http.put("/api/zone/1/config", { "hold": hold }).then(function(response) {
-      console.log("set hold") ;
-    });
+    updateConfig({ "hold": hold }, "set hold");
   }
 
   $scope.incCoolSetpoint = function(val) {
     var temp = $scope.tstat.coolSetpoint + val;
-    $http.put("/api/zone/1/config", { "coolSetpoint": temp }).then(function(response) {
-      console.log("set fan speed") ;
-    });
+    updateConfig({ "coolSetpoint": temp }, "set cool setpoint");
   }
 
   $scope.incHeatSetpoint = function(val) {
     var temp = $scope.tstat.heatSetpoint + val;
-    $http.put("/api/zone/1/config", { "heatSetpoint": temp }).then(function(response) {
-      console.log("set fan speed") ;
-    });
+    updateConfig({ "heatSetpoint": temp }, "set heat setpoint");
   }
 
 /*
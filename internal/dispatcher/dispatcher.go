@@ -45,15 +45,26 @@ func (d *Dispatcher) NewListener() *Listener {
 		ch: make(chan *Event, 32),
 	}
 	l.closeFunc = func() {
-		d.deregisterCh <- l
+		select {
+		case d.deregisterCh <- l:
+		case <-d.ctx.Done():
+		}
 	}
 
-	d.registerCh <- l
+	select {
+	case d.registerCh <- l:
+	case <-d.ctx.Done():
+		// Nothing will ever be sent
+		close(l.ch)
+	}
 	return l
 }
 
 func (d *Dispatcher) BroadcastEvent(source string, data any) {
-	d.broadcast <- &Event{source, data}
+	select {
+	case d.broadcast <- &Event{source, data}:
+	case <-d.ctx.Done():
+	}
 }
 
 func (d *Dispatcher) run() {
@@ -76,6 +87,11 @@ func (d *Dispatcher) run() {
 				}
 			}
 		case <-d.ctx.Done():
+			// Let listeners know there's nothing more to come
+			for listener := range d.listeners {
+				close(listener.ch)
+			}
+			d.listeners = nil
 			return
 		}
 	}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -8,6 +9,8 @@ import (
 	"net/http"
 	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
 	"golang.org/x/net/websocket"
 
@@ -25,21 +28,108 @@ func handleErrors(c *gin.Context) {
 	}
 }
 
+// abortWithBusError aborts the request with a status code describing why a
+// bus request failed.
+func abortWithBusError(c *gin.Context, err error) {
+	var nack *infinity.NackError
+	var decode *infinity.DecodeError
+
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, context.Canceled):
+		// The client went away, there's nobody to respond to.
+		c.Abort()
+		return
+	case errors.Is(err, infinity.ErrInvalidArgument):
+		status = http.StatusBadRequest
+	case errors.Is(err, infinity.ErrReadOnly):
+		status = http.StatusForbidden
+	case errors.Is(err, infinity.ErrNoDefinition):
+		status = http.StatusNotFound
+	case errors.Is(err, infinity.ErrUnconfirmed):
+		status = http.StatusNotImplemented
+	case errors.Is(err, infinity.ErrPortDown), errors.Is(err, infinity.ErrNotSeen), errors.Is(err, infinity.ErrPassive), errors.Is(err, infinity.ErrClosed):
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, infinity.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		status = http.StatusGatewayTimeout
+	case errors.As(err, &nack):
+		log.Warnf("%s %s: %s", c.Request.Method, c.Request.URL.Path, err.Error())
+		c.AbortWithError(http.StatusBadGateway, err).SetMeta(gin.H{
+			"device": fmt.Sprintf("%04x", nack.Device),
+			"code":   uint8(nack.Code),
+			"reason": nack.Code.String(),
+		})
+		return
+	case errors.As(err, &decode):
+		status = http.StatusBadGateway
+	}
+	c.AbortWithError(status, err)
+}
+
 type webserver struct {
 	srv *http.Server
 	api *infinity.Api
+	// Raw table writes are refused unless allowed, and always audited
+	allowRawWrite bool
+	audit         *auditLog
+}
+
+// How long requests in progress get to complete on shutdown
+const shutdownTimeout = 10 * time.Second
+
+var errRawWriteDisabled = errors.New("raw writes are disabled, start infinitive with -allow-raw-write to enable them")
+
+var (
+	deviceRegexp = regexp.MustCompile("^[a-f0-9]{4}$")
+	tableRegexp  = regexp.MustCompile("^[a-f0-9]{6}$")
+)
+
+// parseDevice parses a bus address given as 4 hex digits.
+func parseDevice(s string) (uint16, error) {
+	if !deviceRegexp.MatchString(s) {
+		return 0, fmt.Errorf("device must be a 4 character hex string: %q", s)
+	}
+	d, _ := strconv.ParseUint(s, 16, 16)
+	return uint16(d), nil
+}
+
+// parseTable parses a table address given as 6 hex digits.
+func parseTable(s string) ([]byte, error) {
+	if !tableRegexp.MatchString(s) {
+		return nil, fmt.Errorf("table must be a 6 character hex string: %q", s)
+	}
+	a, _ := hex.DecodeString(s)
+	return a, nil
 }
 
-func launchWebserver(port int, api *infinity.Api) error {
+// launchWebserver serves the API until ctx is done, then shuts down
+// gracefully.
+func launchWebserver(ctx context.Context, port int, api *infinity.Api, allowRawWrite bool, audit *auditLog) error {
 	ws := webserver{
-		api: api,
+		api:           api,
+		allowRawWrite: allowRawWrite,
+		audit:         audit,
 	}
 	ws.srv = &http.Server{
 		Addr:    fmt.Sprintf(":%d", port),
 		Handler: ws.buildEngine().Handler(),
 	}
 
-	return ws.srv.ListenAndServe()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- ws.srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Info("shutting down web server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return ws.srv.Shutdown(shutdownCtx)
 }
 
 func (ws *webserver) buildEngine() *gin.Engine {
@@ -48,11 +138,21 @@ func (ws *webserver) buildEngine() *gin.Engine {
 
 	api := r.Group("/api")
 
+	// Writes are refused outright in passive mode rather than silently
+	// failing on the bus.
+	writable := func(c *gin.Context) {
+		if ws.api.Bus.Passive() {
+			c.AbortWithError(http.StatusForbidden, infinity.ErrReadOnly)
+		}
+	}
+
 	api.GET("/tstat/settings", func(c *gin.Context) {
-		tss, ok := ws.api.GetTstatSettings()
-		if ok {
-			c.JSON(200, tss)
+		tss, err := ws.api.GetTstatSettingsContext(c.Request.Context())
+		if err != nil {
+			abortWithBusError(c, err)
+			return
 		}
+		c.JSON(200, tss)
 	})
 
 	parseZone := func(c *gin.Context) (int, bool) {
@@ -64,16 +164,27 @@ func (ws *webserver) buildEngine() *gin.Engine {
 		return zone, true
 	}
 
+	api.GET("/zones", func(c *gin.Context) {
+		zones, err := ws.api.GetZonesContext(c.Request.Context())
+		if err != nil {
+			abortWithBusError(c, err)
+			return
+		}
+		c.JSON(200, zones)
+	})
+
 	api.GET("/zone/:zone/config", func(c *gin.Context) {
 		zone, ok := parseZone(c)
 		if !ok {
 			return
 		}
 
-		cfg, ok := ws.api.GetConfig(zone)
-		if ok {
-			c.JSON(200, cfg)
+		cfg, err := ws.api.GetConfigContext(c.Request.Context(), zone)
+		if err != nil {
+			abortWithBusError(c, err)
+			return
 		}
+		c.JSON(200, cfg)
 	})
 
 	getAirHandler := func(c *gin.Context) {
@@ -96,15 +207,25 @@ func (ws *webserver) buildEngine() *gin.Engine {
 	api.GET("/zone/1/airhandler", getAirHandler)
 	api.GET("/zone/1/heatpump", getHeatPump)
 
-	api.GET("/zone/1/vacation", func(c *gin.Context) {
+	// Vacation is set for the whole system, so every zone shares it
+	api.GET("/zone/:zone/vacation", func(c *gin.Context) {
+		if _, ok := parseZone(c); !ok {
+			return
+		}
+
 		vac := infinity.TStatVacationParams{}
-		ok := ws.api.Bus.ReadTable(infinity.DevTSTAT, &vac)
-		if ok {
-			c.JSON(200, vac.ToAPI())
+		if err := ws.api.Bus.ReadTableContext(c.Request.Context(), infinity.DevTSTAT, &vac); err != nil {
+			abortWithBusError(c, err)
+			return
 		}
+		c.JSON(200, vac.ToAPI())
 	})
 
-	api.PUT("/zone/1/vacation", func(c *gin.Context) {
+	api.PUT("/zone/:zone/vacation", writable, func(c *gin.Context) {
+		if _, ok := parseZone(c); !ok {
+			return
+		}
+
 		var args infinity.APIVacationConfig
 
 		if c.Bind(&args) != nil {
@@ -115,10 +236,12 @@ func (ws *webserver) buildEngine() *gin.Engine {
 		params := infinity.TStatVacationParams{}
 		flags := params.FromAPI(&args)
 
-		ws.api.UpdateThermostat(params, flags)
+		if err := ws.api.UpdateThermostatContext(c.Request.Context(), params, flags); err != nil {
+			abortWithBusError(c, err)
+		}
 	})
 
-	api.PUT("/zone/:zone/config", func(c *gin.Context) {
+	api.PUT("/zone/:zone/config", writable, func(c *gin.Context) {
 		zone, ok := parseZone(c)
 		if !ok {
 			return
@@ -130,75 +253,292 @@ func (ws *webserver) buildEngine() *gin.Engine {
 			return
 		}
 
+		if args.TargetHumidity > 0 {
+			abortWithBusError(c, fmt.Errorf("%w: humidity targets can't be set yet", infinity.ErrUnconfirmed))
+			return
+		}
+
+		if len(args.Name) > 0 {
+			abortWithBusError(c, fmt.Errorf("%w: zones can't be renamed yet", infinity.ErrUnconfirmed))
+			return
+		}
+
 		params := infinity.TStatZoneParams{}
-		flags := byte(0)
+		fields := []string{}
+
+		if len(args.FanMode) > 0 || args.Hold != nil || args.HeatSetpoint > 0 || args.CoolSetpoint > 0 {
+			// We have to read the current settings since every field holds all
+			// zones and we need to retain the configuration for other zones.
+			if err := ws.api.Bus.ReadTableContext(c.Request.Context(), infinity.DevTSTAT, &params); err != nil {
+				abortWithBusError(c, err)
+				return
+			}
+		}
 
 		if len(args.FanMode) > 0 {
 			mode, _ := infinity.StringFanModeToRaw(args.FanMode)
 			// FIXME: check for ok here
-			params.SetZonalField(zone, "FanMode", mode)
-			flags |= 0x01
+			params.FanMode[zone-1] = mode
+			fields = append(fields, "FanMode")
 		}
 
 		if args.Hold != nil {
-			// We have to read the current settings since hold is a bitfield and we need to
-			// retain the configuration for other zones.
-			priorParams := infinity.TStatZoneParams{}
-			ok := ws.api.Bus.ReadTable(infinity.DevTSTAT, &priorParams)
-			if !ok {
-				c.AbortWithStatus(http.StatusInternalServerError)
-				return
-			}
-
-			params.ZoneHold = priorParams.ZoneHold
 			if *args.Hold {
 				params.ZoneHold |= 1 << (zone - 1)
 			} else {
 				params.ZoneHold &= ^(1 << (zone - 1))
 			}
-			flags |= 0x02
+			fields = append(fields, "ZoneHold")
 		}
 
 		if args.HeatSetpoint > 0 {
-			params.SetZonalField(zone, "HeatSetpoint", args.HeatSetpoint)
-			flags |= 0x04
+			params.HeatSetpoint[zone-1] = args.HeatSetpoint
+			fields = append(fields, "HeatSetpoint")
 		}
 
 		if args.CoolSetpoint > 0 {
-			params.SetZonalField(zone, "CoolSetpoint", args.CoolSetpoint)
-			flags |= 0x08
+			params.CoolSetpoint[zone-1] = args.CoolSetpoint
+			fields = append(fields, "CoolSetpoint")
 		}
 
-		if flags != 0 {
-			ws.api.UpdateThermostat(params, flags)
+		if len(fields) > 0 {
+			flags := infinity.LayoutOf(params).Flags(fields...)
+			if err := ws.api.UpdateThermostatContext(c.Request.Context(), params, flags); err != nil {
+				abortWithBusError(c, err)
+				return
+			}
 		}
 
 		if len(args.Mode) > 0 {
 			p := infinity.TStatCurrentParams{Mode: infinity.StringModeToRaw(args.Mode)}
-			ws.api.UpdateThermostat(p, 0x10)
+			if err := ws.api.UpdateThermostatContext(c.Request.Context(), p, infinity.LayoutOf(p).Flags("Mode")); err != nil {
+				abortWithBusError(c, err)
+				return
+			}
 		}
 	})
 
 	api.GET("/raw/:device/:table", func(c *gin.Context) {
-		matched, _ := regexp.MatchString("^[a-f0-9]{4}$", c.Param("device"))
-		if !matched {
-			c.AbortWithError(400, errors.New("name must be a 4 character hex string"))
+		d, err := parseDevice(c.Param("device"))
+		if err != nil {
+			c.AbortWithError(400, err)
 			return
 		}
-		matched, _ = regexp.MatchString("^[a-f0-9]{6}$", c.Param("table"))
-		if !matched {
-			c.AbortWithError(400, errors.New("table must be a 6 character hex string"))
+		a, err := parseTable(c.Param("table"))
+		if err != nil {
+			c.AbortWithError(400, err)
 			return
 		}
 
-		d, _ := strconv.ParseUint(c.Param("device"), 16, 16)
-		a, _ := hex.DecodeString(c.Param("table"))
+		body, err := ws.api.GetTableBodyContext(c.Request.Context(), d, a)
+		if err != nil {
+			abortWithBusError(c, err)
+			return
+		}
+		// The response is the table without its header, as it always was
+		res := gin.H{"response": hex.EncodeToString(body[3:])}
+		if decoded, err := ws.api.DecodeTable(d, a, body); err == nil {
+			res["decoded"] = decoded.Fields
+		}
+		c.JSON(200, res)
+	})
+
+	api.PUT("/raw/:device/:table", func(c *gin.Context) {
+		var args struct {
+			Prefix string `json:"prefix"`
+			Data   string `json:"data"`
+			DryRun bool   `json:"dryRun"`
+		}
+		bindErr := c.ShouldBindJSON(&args)
+
+		// Every attempt is audited, including those rejected before
+		// reaching the bus
+		entry := auditEntry{
+			Time:   time.Now(),
+			Client: c.ClientIP(),
+			Device: c.Param("device"),
+			Table:  c.Param("table"),
+			Prefix: args.Prefix,
+			Data:   args.Data,
+			DryRun: args.DryRun,
+		}
+		reject := func(status int, err error) {
+			entry.Error = err.Error()
+			ws.audit.record(entry)
+			c.AbortWithError(status, err)
+		}
 
-		if response := ws.api.GetTableRaw(uint16(d), a); response != nil {
-			c.JSON(200, gin.H{"response": hex.EncodeToString(response)})
-		} else {
-			c.AbortWithError(504, errors.New("timed out waiting for response"))
+		if !ws.allowRawWrite {
+			reject(http.StatusForbidden, errRawWriteDisabled)
+			return
+		}
+		d, err := parseDevice(c.Param("device"))
+		if err != nil {
+			reject(400, err)
+			return
+		}
+		a, err := parseTable(c.Param("table"))
+		if err != nil {
+			reject(400, err)
+			return
+		}
+		if bindErr != nil {
+			reject(400, bindErr)
+			return
 		}
+		prefix, err := hex.DecodeString(args.Prefix)
+		if err != nil || len(prefix) != 3 {
+			reject(400, errors.New("prefix must be a 6 character hex string"))
+			return
+		}
+		data, err := hex.DecodeString(args.Data)
+		if err != nil {
+			reject(400, errors.New("data must be a hex string"))
+			return
+		}
+
+		w, err := ws.api.WriteTableRawContext(c.Request.Context(), d, a, prefix, data, args.DryRun)
+		entry.Write = w
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		ws.audit.record(entry)
+
+		if err != nil {
+			abortWithBusError(c, err)
+			return
+		}
+		c.JSON(200, w)
+	})
+
+	api.POST("/raw/:device/op/:op", func(c *gin.Context) {
+		op, ok := infinity.ParseOp(c.Param("op"))
+		if !ok {
+			c.AbortWithError(400, fmt.Errorf("unknown operation %q", c.Param("op")))
+			return
+		}
+		var args struct {
+			Data string `json:"data"`
+		}
+		bindErr := c.ShouldBindJSON(&args)
+
+		// Requests changing a device's state are as risky as raw table
+		// writes, so they are gated and audited the same way, rejected
+		// attempts included
+		changesState := infinity.ChangesState(op)
+		entry := auditEntry{
+			Time:   time.Now(),
+			Client: c.ClientIP(),
+			Device: c.Param("device"),
+			Op:     strings.ToUpper(c.Param("op")),
+			Data:   args.Data,
+		}
+		reject := func(status int, err error) {
+			if changesState {
+				entry.Error = err.Error()
+				ws.audit.record(entry)
+			}
+			c.AbortWithError(status, err)
+		}
+
+		if changesState && !ws.allowRawWrite {
+			reject(http.StatusForbidden, errRawWriteDisabled)
+			return
+		}
+		d, err := parseDevice(c.Param("device"))
+		if err != nil {
+			reject(400, err)
+			return
+		}
+		if bindErr != nil {
+			reject(400, bindErr)
+			return
+		}
+		data, err := hex.DecodeString(args.Data)
+		if err != nil {
+			reject(400, errors.New("data must be a hex string"))
+			return
+		}
+
+		response, err := ws.api.RequestRawContext(c.Request.Context(), d, op, data)
+		if changesState {
+			entry.Response = hex.EncodeToString(response)
+			if err != nil {
+				entry.Error = err.Error()
+			}
+			ws.audit.record(entry)
+		}
+		if err != nil {
+			abortWithBusError(c, err)
+			return
+		}
+		res := gin.H{"response": hex.EncodeToString(response)}
+		// Table reads are answered with the table address, usually a header,
+		// and the table itself
+		if op == infinity.ReadTableBlock && len(response) >= 3 {
+			if decoded, err := ws.api.DecodeTable(d, response[0:3], response[3:]); err == nil {
+				res["decoded"] = decoded.Fields
+			}
+		}
+		c.JSON(200, res)
+	})
+
+	api.GET("/tables/:device/:table/decoded", func(c *gin.Context) {
+		d, err := parseDevice(c.Param("device"))
+		if err != nil {
+			c.AbortWithError(400, err)
+			return
+		}
+		a, err := parseTable(c.Param("table"))
+		if err != nil {
+			c.AbortWithError(400, err)
+			return
+		}
+
+		decoded, err := ws.api.GetTableDecodedContext(c.Request.Context(), d, a)
+		if err != nil {
+			abortWithBusError(c, err)
+			return
+		}
+		c.JSON(200, decoded)
+	})
+
+	api.POST("/decode", func(c *gin.Context) {
+		var args struct {
+			Frame string `json:"frame"`
+		}
+		if err := c.BindJSON(&args); err != nil {
+			return
+		}
+		// Accept frames copied from logs or hex dumps
+		buf, err := hex.DecodeString(strings.NewReplacer(" ", "", ":", "", "\n", "").Replace(args.Frame))
+		if err != nil {
+			c.AbortWithError(400, errors.New("frame must be a hex string"))
+			return
+		}
+
+		d, err := ws.api.Bus.Dissect(buf)
+		if err != nil {
+			abortWithBusError(c, err)
+			return
+		}
+		c.JSON(200, d)
+	})
+
+	api.GET("/alarms", func(c *gin.Context) {
+		c.JSON(200, ws.api.Alarms(c.Query("active") == "true"))
+	})
+
+	api.GET("/devices", func(c *gin.Context) {
+		c.JSON(200, ws.api.Devices())
+	})
+
+	api.GET("/bus/stats", func(c *gin.Context) {
+		c.JSON(200, ws.api.Bus.Stats())
+	})
+
+	api.GET("/bus/link", func(c *gin.Context) {
+		c.JSON(200, ws.api.Bus.Link())
 	})
 
 	api.GET("/ws", func(c *gin.Context) {
@@ -206,6 +546,23 @@ func (ws *webserver) buildEngine() *gin.Engine {
 		h.ServeHTTP(c.Writer, c.Request)
 	})
 
+	api.GET("/bus/ws", func(c *gin.Context) {
+		filter, err := parseFrameFilter(c)
+		if err != nil {
+			c.AbortWithError(400, err)
+			return
+		}
+		h := websocket.Handler(func(wsConn *websocket.Conn) {
+			ws.framesListener(wsConn, filter)
+		})
+		h.ServeHTTP(c.Writer, c.Request)
+	})
+
+	api.GET("/changes/ws", func(c *gin.Context) {
+		h := websocket.Handler(ws.changesListener)
+		h.ServeHTTP(c.Writer, c.Request)
+	})
+
 	r.StaticFS("/ui", http.FS(assets.Assets))
 
 	r.GET("/", func(c *gin.Context) {
@@ -246,3 +603,107 @@ func (ws *webserver) websocketListener(wsConn *websocket.Conn) {
 		}
 	}
 }
+
+// changesListener streams field changes, without the snapshots the UI
+// websocket starts with.
+func (ws *webserver) changesListener(wsConn *websocket.Conn) {
+	listener := ws.api.NewChangeListener()
+
+	defer func() {
+		listener.Close()
+		log.Infof("%s: closing changes websocket", wsConn.RemoteAddr())
+		if err := wsConn.Close(); err != nil {
+			log.Errorf("%s: error on closing wsConn: %v", wsConn.RemoteAddr(), err)
+		}
+	}()
+
+	for message := range listener.Receive() {
+		if _, err := wsConn.Write(serializeUpdate(message.Source, message.Data)); err != nil {
+			log.Infof("%s: error writing to wsConn: %v", wsConn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+// frameFilter selects the frames streamed to a client.  Empty sets match
+// everything.
+type frameFilter struct {
+	devices map[string]bool
+	ops     map[string]bool
+	tables  map[string]bool
+}
+
+// parseFrameFilter reads a filter from the device, op and table query
+// parameters, each a comma separated list.
+func parseFrameFilter(c *gin.Context) (frameFilter, error) {
+	f := frameFilter{
+		devices: make(map[string]bool),
+		ops:     make(map[string]bool),
+		tables:  make(map[string]bool),
+	}
+	split := func(name string) []string {
+		if v := c.Query(name); v != "" {
+			return strings.Split(strings.ToLower(v), ",")
+		}
+		return nil
+	}
+
+	for _, d := range split("device") {
+		if _, err := parseDevice(d); err != nil {
+			return f, err
+		}
+		f.devices[d] = true
+	}
+	for _, o := range split("op") {
+		if _, ok := infinity.ParseOp(o); !ok {
+			return f, fmt.Errorf("unknown operation %q", o)
+		}
+		// Frames name their operations in upper case
+		f.ops[strings.ToUpper(o)] = true
+	}
+	for _, t := range split("table") {
+		if _, err := parseTable(t); err != nil {
+			return f, err
+		}
+		f.tables[t] = true
+	}
+	return f, nil
+}
+
+func (f frameFilter) matches(frame infinity.BusFrame) bool {
+	if len(f.devices) > 0 && !f.devices[frame.Src.Address] && !f.devices[frame.Dst.Address] {
+		return false
+	}
+	if len(f.ops) > 0 && !f.ops[frame.Op] {
+		return false
+	}
+	if len(f.tables) > 0 && (frame.Table == nil || !f.tables[frame.Table.Address]) {
+		return false
+	}
+	return true
+}
+
+// framesListener streams the frames read from and sent on the bus that
+// match filter.
+func (ws *webserver) framesListener(wsConn *websocket.Conn, filter frameFilter) {
+	listener := ws.api.NewFrameListener()
+
+	defer func() {
+		listener.Close()
+		log.Infof("%s: closing bus websocket", wsConn.RemoteAddr())
+		if err := wsConn.Close(); err != nil {
+			log.Errorf("%s: error on closing wsConn: %v", wsConn.RemoteAddr(), err)
+		}
+	}()
+
+	for message := range listener.Receive() {
+		frame, ok := message.Data.(infinity.BusFrame)
+		if !ok || !filter.matches(frame) {
+			continue
+		}
+		if _, err := wsConn.Write(serializeUpdate(message.Source, frame)); err != nil {
+			log.Infof("%s: error writing to wsConn: %v", wsConn.RemoteAddr(), err)
+			return
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/acd/infinitive/infinity"
+	"github.com/gin-gonic/gin"
+)
+
+func testFrameFilter(query string) (frameFilter, error) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/api/bus/ws?"+query, nil)
+	return parseFrameFilter(c)
+}
+
+func TestParseFrameFilter(t *testing.T) {
+	for _, query := range []string{
+		"device=20011",
+		"device=2001,",
+		"device=200g",
+		"op=bogus",
+		"op=read,",
+		"table=3b02",
+		"table=003b02,003b0x",
+	} {
+		if _, err := testFrameFilter(query); err == nil {
+			t.Errorf("%s: accepted", query)
+		}
+	}
+
+	f, err := testFrameFilter("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.devices)+len(f.ops)+len(f.tables) != 0 {
+		t.Errorf("empty query parsed as %+v", f)
+	}
+
+	f, err = testFrameFilter("device=2001,5001&op=read,Ack06&table=003E01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := frameFilter{
+		devices: map[string]bool{"2001": true, "5001": true},
+		ops:     map[string]bool{"READ": true, "ACK06": true},
+		tables:  map[string]bool{"003e01": true},
+	}
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("parsed %+v, want %+v", f, want)
+	}
+}
+
+func TestFrameFilterMatches(t *testing.T) {
+	f, err := testFrameFilter("device=5001&op=ack06,read&table=003e01")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frame := func(src, dst, op, table string) infinity.BusFrame {
+		bf := infinity.BusFrame{
+			Src: infinity.Endpoint{Address: src},
+			Dst: infinity.Endpoint{Address: dst},
+			Op:  op,
+		}
+		if table != "" {
+			bf.Table = &infinity.DissectedTable{Address: table}
+		}
+		return bf
+	}
+	tests := []struct {
+		name  string
+		frame infinity.BusFrame
+		want  bool
+	}{
+		{"response from the device", frame("5001", "2001", "ACK06", "003e01"), true},
+		{"read sent to the device", frame("2001", "5001", "READ", "003e01"), true},
+		{"other devices", frame("2001", "4001", "READ", "003e01"), false},
+		{"other op", frame("5001", "2001", "WRITE", "003e01"), false},
+		{"other table", frame("5001", "2001", "ACK06", "003e02"), false},
+		{"no table", frame("5001", "2001", "ACK06", ""), false},
+	}
+	for _, tt := range tests {
+		if got := f.matches(tt.frame); got != tt.want {
+			t.Errorf("%s: matches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	all, _ := testFrameFilter("")
+	if !all.matches(frame("2001", "4001", "NACK", "")) {
+		t.Error("empty filter doesn't match everything")
+	}
+}
+
+func TestParseDeviceAndTable(t *testing.T) {
+	for s, want := range map[string]uint16{"2001": 0x2001, "9201": 0x9201, "00ff": 0x00ff} {
+		if d, err := parseDevice(s); err != nil || d != want {
+			t.Errorf("parseDevice(%q) = %04x, %v", s, d, err)
+		}
+	}
+	for _, s := range []string{"", "201", "20011", "200G", "200g", " 2001"} {
+		if _, err := parseDevice(s); err == nil {
+			t.Errorf("parseDevice(%q) accepted", s)
+		}
+	}
+
+	if a, err := parseTable("003b03"); err != nil || !reflect.DeepEqual(a, []byte{0x00, 0x3b, 0x03}) {
+		t.Errorf("parseTable(003b03) = %x, %v", a, err)
+	}
+	for _, s := range []string{"", "3b03", "003b031", "003B03", "003b0x"} {
+		if _, err := parseTable(s); err == nil {
+			t.Errorf("parseTable(%q) accepted", s)
+		}
+	}
+}